@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Values of ClassSession.StoppedBy other than a user ID.
+const (
+	stoppedBySchedule = "schedule" // The class reached its scheduled end
+	stoppedByReplaced = "replaced" // Tracking was restarted for the same sheet
+	stoppedByShutdown = "shutdown" // The bot shut down while tracking
+)
+
+// ==================================CLASS SESSIONS===========================================
+// ClassSession is one meeting of a class, recorded in the class_sessions table
+// when attendance tracking starts and closed when it stops. Attendance marks
+// are computed against it.
+type ClassSession struct {
+	ID             int64
+	GuildID        string
+	ClassName      string
+	SheetName      string
+	Date           string // YYYY-MM-DD in the class's timezone
+	ScheduledStart time.Time
+	ScheduledEnd   time.Time
+	ActualStart    time.Time
+	ActualEnd      time.Time // Zero while the session is open
+	StoppedBy      string    // User ID, or one of the stoppedBy constants
+	ChannelID      string    // Text channel tracking was started from
+	VoiceChannel   string    // Name of the class's voice channel, empty for any
+}
+
+// newClassSession describes today's meeting of class, tracked into sheetName
+// from channelID.
+func newClassSession(guildID, channelID, sheetName string, class *ClassSchedule) *ClassSession {
+	now := time.Now()
+	start := class.StartOn(now)
+	return &ClassSession{
+		GuildID:        guildID,
+		ClassName:      class.Name,
+		SheetName:      sheetName,
+		Date:           now.In(class.Location()).Format("2006-01-02"),
+		ScheduledStart: start,
+		ScheduledEnd:   start.Add(class.Duration),
+		ActualStart:    now.UTC(),
+		ChannelID:      channelID,
+		VoiceChannel:   class.VoiceChannel,
+	}
+}
+
+// End is the end of the class used for attendance: when it was stopped, or
+// the scheduled end while it is running. A bot shutdown does not end the
+// class.
+func (c *ClassSession) End() time.Time {
+	if c.StoppedBy != stoppedByShutdown && !c.ActualEnd.IsZero() && c.ActualEnd.Before(c.ScheduledEnd) {
+		return c.ActualEnd
+	}
+	return c.ScheduledEnd
+}
+
+// ===================================Class session database===========================================
+func insertClassSession(db *sql.DB, session *ClassSession) error {
+	res, err := db.Exec(`
+		INSERT INTO class_sessions (guild_id, class_name, sheet_name, session_date, scheduled_start, scheduled_end, actual_start, channel_id, voice_channel)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, session.GuildID, session.ClassName, session.SheetName, session.Date,
+		session.ScheduledStart, session.ScheduledEnd, session.ActualStart, session.ChannelID, session.VoiceChannel)
+	if err != nil {
+		return fmt.Errorf("error inserting class session: %v", err)
+	}
+	session.ID, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error reading class session ID: %v", err)
+	}
+	return nil
+}
+
+// closeClassSession records when and by whom the session was stopped. The
+// end stays empty when the bot shut down, so the session can be resumed.
+func closeClassSession(db *sql.DB, session *ClassSession) error {
+	actualEnd := sql.NullTime{Time: session.ActualEnd, Valid: !session.ActualEnd.IsZero()}
+	_, err := db.Exec(`UPDATE class_sessions SET actual_end = ?, stopped_by = ? WHERE id = ?`,
+		actualEnd, session.StoppedBy, session.ID)
+	if err != nil {
+		return fmt.Errorf("error closing class session: %v", err)
+	}
+	return nil
+}
+
+// reopenClassSession marks a resumed session as running again.
+func reopenClassSession(db *sql.DB, session *ClassSession) error {
+	_, err := db.Exec(`UPDATE class_sessions SET actual_end = NULL, stopped_by = '' WHERE id = ?`, session.ID)
+	if err != nil {
+		return fmt.Errorf("error reopening class session: %v", err)
+	}
+	session.ActualEnd, session.StoppedBy = time.Time{}, ""
+	return nil
+}
+
+// fetchClassSessions returns the sessions of className (every class when
+// empty) held between the dates from and to, inclusive and as YYYY-MM-DD.
+// An empty date leaves that end open. Sessions replaced by a restart are left
+// out.
+func fetchClassSessions(db *sql.DB, guildID, className, from, to string) ([]ClassSession, error) {
+	conditions := "stopped_by != ?"
+	args := []interface{}{stoppedByReplaced}
+	if className != "" {
+		conditions += " AND class_name = ? COLLATE NOCASE"
+		args = append(args, className)
+	}
+	if from != "" {
+		conditions += " AND session_date >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		conditions += " AND session_date <= ?"
+		args = append(args, to)
+	}
+	return queryClassSessions(db, guildID, conditions, args...)
+}
+
+// fetchUnfinishedSessions returns the sessions that were still running when
+// the bot stopped and whose class has not ended by now.
+func fetchUnfinishedSessions(db *sql.DB, guildID string, now time.Time) ([]ClassSession, error) {
+	return queryClassSessions(db, guildID, "stopped_by IN ('', ?) AND scheduled_end > ?", stoppedByShutdown, now)
+}
+
+// queryClassSessions returns the guild's sessions matching conditions, in
+// order of their start.
+func queryClassSessions(db *sql.DB, guildID, conditions string, args ...interface{}) ([]ClassSession, error) {
+	query := `
+		SELECT id, class_name, sheet_name, session_date, scheduled_start, scheduled_end, actual_start, actual_end, stopped_by, channel_id, voice_channel
+		FROM class_sessions
+		WHERE guild_id = ? AND ` + conditions + `
+		ORDER BY scheduled_start
+	`
+	rows, err := db.Query(query, append([]interface{}{guildID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching class sessions: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []ClassSession
+	for rows.Next() {
+		session := ClassSession{GuildID: guildID}
+		var actualEnd sql.NullTime
+		err := rows.Scan(&session.ID, &session.ClassName, &session.SheetName, &session.Date,
+			&session.ScheduledStart, &session.ScheduledEnd, &session.ActualStart, &actualEnd, &session.StoppedBy, &session.ChannelID, &session.VoiceChannel)
+		if err != nil {
+			return nil, fmt.Errorf("error reading class session: %v", err)
+		}
+		session.ActualEnd = actualEnd.Time
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownKeepsSessionOpen(t *testing.T) {
+	conn := useTestDatabase(t)
+	start := time.Now().UTC().Add(-30 * time.Minute).Truncate(time.Second)
+	session := &ClassSession{
+		GuildID:        "g1",
+		ClassName:      "Math",
+		SheetName:      "Math",
+		Date:           start.Format("2006-01-02"),
+		ScheduledStart: start,
+		ScheduledEnd:   start.Add(time.Hour),
+		ActualStart:    start,
+		ChannelID:      "c1",
+	}
+	if err := insertClassSession(conn, session); err != nil {
+		t.Fatal(err)
+	}
+
+	job := newAttendanceJob(session, &ClassSchedule{GuildID: "g1", Name: "Math"})
+	closed := job.finish(time.Now().UTC(), stoppedByShutdown)
+	if !closed.ActualEnd.IsZero() {
+		t.Errorf("shutdown set the actual end to %v", closed.ActualEnd)
+	}
+	if !closed.End().Equal(session.ScheduledEnd) {
+		t.Errorf("End() = %v, want the scheduled end %v", closed.End(), session.ScheduledEnd)
+	}
+	if err := closeClassSession(conn, &closed); err != nil {
+		t.Fatal(err)
+	}
+
+	unfinished, err := fetchUnfinishedSessions(conn, "g1", time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unfinished) != 1 || unfinished[0].ID != session.ID {
+		t.Fatalf("unfinished sessions = %+v, want session %d", unfinished, session.ID)
+	}
+	resumed := unfinished[0]
+	if resumed.ChannelID != "c1" || !resumed.ActualEnd.IsZero() || resumed.StoppedBy != stoppedByShutdown {
+		t.Errorf("stored session = %+v", resumed)
+	}
+
+	if err := reopenClassSession(conn, &resumed); err != nil {
+		t.Fatal(err)
+	}
+	stopped := newAttendanceJob(&resumed, &ClassSchedule{GuildID: "g1", Name: "Math"}).finish(start.Add(45*time.Minute), "u1")
+	if err := closeClassSession(conn, &stopped); err != nil {
+		t.Fatal(err)
+	}
+	unfinished, err = fetchUnfinishedSessions(conn, "g1", time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unfinished) != 0 {
+		t.Errorf("session stopped by a user is still unfinished: %+v", unfinished)
+	}
+}
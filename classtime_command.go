@@ -2,60 +2,174 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// ClassTimeOptions are the optional settings of `!setclasstime`.
+type ClassTimeOptions struct {
+	Name         string
+	Days         string
+	Duration     string
+	Timezone     string
+	VoiceChannel string
+}
+
+// parseClassTimeArgs splits prefix arguments into key=value settings
+// (days, duration, tz, channel) and the class name made of the remaining words.
+func parseClassTimeArgs(args []string) (ClassTimeOptions, error) {
+	settings, words := splitSettings(args)
+	opts := ClassTimeOptions{Name: strings.Join(words, " ")}
+	for key, value := range settings {
+		switch key {
+		case "days":
+			opts.Days = value
+		case "duration":
+			opts.Duration = value
+		case "tz", "timezone":
+			opts.Timezone = value
+		case "channel":
+			opts.VoiceChannel = value
+		default:
+			return opts, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return opts, nil
+}
+
 // ==================================CLASS TIME, DELETE TIME===========================================
-func setClassTime(s *discordgo.Session, m *discordgo.MessageCreate, classTime string) {
-	// Load the GMT+7 timezone
-	location, err := time.LoadLocation("Asia/Bangkok")
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Failed to load timezone data.")
+func setClassTime(ctx *CommandContext, classTime string, opts ClassTimeOptions) {
+	if _, err := time.Parse("15:04", classTime); err != nil {
+		ctx.Reply("Invalid time format. Please use format HH:MM.")
 		return
 	}
 
-	parsedTime, err := time.ParseInLocation("15:04", classTime, location)
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Invalid time format. Please use format HH:MM.")
-		return
+	schedule := &ClassSchedule{
+		GuildID:      ctx.GuildID,
+		Name:         opts.Name,
+		StartTime:    classTime,
+		Duration:     cfg.ClassDurationFor(ctx.GuildID),
+		Timezone:     guildTimezone(ctx.GuildID),
+		VoiceChannel: opts.VoiceChannel,
+	}
+	if schedule.Name == "" {
+		schedule.Name = defaultClassName
 	}
 
-	utcTime := parsedTime.UTC().Add(-17 * time.Minute)
+	// Keep the settings that were not given when updating an existing class.
+	if existing, ok := findClassSchedule(ctx.GuildID, schedule.Name); ok {
+		schedule.Name = existing.Name
+		schedule.Weekdays = existing.Weekdays
+		schedule.Duration = existing.Duration
+		schedule.Timezone = existing.Timezone
+		if opts.VoiceChannel == "" {
+			schedule.VoiceChannel = existing.VoiceChannel
+		}
+	}
 
-	classTimes[m.GuildID] = utcTime
+	if opts.Days != "" {
+		days, err := parseWeekdays(opts.Days)
+		if err != nil {
+			ctx.Reply(fmt.Sprintf("Invalid days: %v. Use e.g. `days=mon,wed,fri` or `days=daily`.", err))
+			return
+		}
+		schedule.Weekdays = days
+	}
+	if opts.Timezone != "" {
+		loc, err := time.LoadLocation(opts.Timezone)
+		if err != nil || opts.Timezone == "Local" {
+			ctx.Reply(fmt.Sprintf("Unknown timezone '%s'. Use an IANA name such as `tz=Asia/Bangkok`.", opts.Timezone))
+			return
+		}
+		schedule.Timezone = loc.String()
+	}
+	if opts.Duration != "" {
+		d, err := time.ParseDuration(opts.Duration)
+		if err != nil || d <= 0 {
+			ctx.Reply("Invalid duration. Use e.g. `duration=90m` or `duration=1h30m`.")
+			return
+		}
+		schedule.Duration = d
+	}
 
-	showClassTime(s, m)
+	if err := saveClassSchedule(guildDB(ctx.GuildID), schedule); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save class time.")
+		return
+	}
 
-	// s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Class time set to %s GMT+7.", utcTime.Format("15:04")))
+	showClassTime(ctx, schedule.Name)
 }
 
-func showClassTime(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if utcClassTime, ok := classTimes[m.GuildID]; ok {
-		// Convert UTC time to GMT+7 for display
-		location, _ := time.LoadLocation("Asia/Bangkok") // GMT+7
-		localTime := utcClassTime.In(location).Add(17 * time.Minute)
+func showClassTime(ctx *CommandContext, className string) {
+	if className != "" {
+		schedule, ok := findClassSchedule(ctx.GuildID, className)
+		if !ok {
+			ctx.Reply(fmt.Sprintf("No class time is set for '%s'.", className))
+			return
+		}
+		ctx.Reply(fmt.Sprintf("Class time for '%s' is %s.", schedule.Name, describeClassSchedule(schedule)))
+		return
+	}
+
+	schedules := guildClassSchedules(ctx.GuildID)
+	if len(schedules) == 0 {
+		ctx.Reply("No class time is set.")
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Class Times",
+		Color: 0x00ff00, // Green color
+	}
+	for _, schedule := range schedules {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   schedule.Name,
+			Value:  describeClassSchedule(schedule),
+			Inline: false,
+		})
+	}
+	ctx.ReplyEmbed(embed)
+}
 
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Current class time is %s.", localTime.Format("15:04")))
-	} else {
-		s.ChannelMessageSend(m.ChannelID, "No class time is set.")
+func describeClassSchedule(schedule *ClassSchedule) string {
+	desc := fmt.Sprintf("%s %s, %s for %s", schedule.StartTime, schedule.Timezone, schedule.WeekdaysString(), schedule.Duration)
+	if schedule.VoiceChannel != "" {
+		desc += " in " + schedule.VoiceChannel
 	}
+	next := schedule.NextStart(time.Now()).In(schedule.Location())
+	return desc + ", next on " + next.Format("Mon 2006-01-02 15:04")
 }
 
-func deleteClassTime(s *discordgo.Session, m *discordgo.MessageCreate, classTime string) {
-	_, err := time.Parse("15:04", classTime)
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Invalid time format. Please use format HH:MM.")
-		return
+// deleteClassTime removes a class by name, or every class starting at the
+// given HH:MM time.
+func deleteClassTime(ctx *CommandContext, target string) {
+	var names []string
+	if _, err := time.Parse("15:04", target); err == nil {
+		for _, schedule := range guildClassSchedules(ctx.GuildID) {
+			if schedule.StartTime == target {
+				names = append(names, schedule.Name)
+			}
+		}
+	} else if schedule, ok := findClassSchedule(ctx.GuildID, target); ok {
+		names = append(names, schedule.Name)
 	}
 
-	if _, ok := classTimes[m.GuildID]; !ok {
-		s.ChannelMessageSend(m.ChannelID, "Class time is not set.")
+	if len(names) == 0 {
+		ctx.Reply("Class time is not set.")
 		return
 	}
 
-	delete(classTimes, m.GuildID)
-	s.ChannelMessageSend(m.ChannelID, "Class time deleted.")
+	for _, name := range names {
+		if err := deleteClassSchedule(guildDB(ctx.GuildID), ctx.GuildID, name); err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to delete class time.")
+			return
+		}
+	}
+	ctx.Reply(fmt.Sprintf("Class time deleted: %s.", strings.Join(names, ", ")))
 }
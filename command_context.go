@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Attachment is a file sent along with a reply. It is kept in memory so a
+// failed send can be retried with the same content.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+func (a *Attachment) file() *discordgo.File {
+	return &discordgo.File{Name: a.Name, ContentType: a.ContentType, Reader: bytes.NewReader(a.Data)}
+}
+
+// CommandContext carries everything a command handler needs, whether the
+// command arrived as a prefix message or as a slash command interaction.
+type CommandContext struct {
+	Session   *discordgo.Session
+	GuildID   string
+	ChannelID string
+	Author    *discordgo.User
+	Member    *discordgo.Member
+
+	// Interaction is nil for prefix commands.
+	Interaction *discordgo.Interaction
+
+	// Attachments are the files sent with a prefix command, or the resolved
+	// attachment options of a slash command.
+	Attachments []*discordgo.MessageAttachment
+
+	// Command is the registry entry being run and RawArgs the unsplit text
+	// after a prefix command's name.
+	Command *Command
+	RawArgs string
+
+	// mu guards the interaction response state: attendance jobs reply from
+	// their own goroutine while the handler may still be replying.
+	mu        sync.Mutex
+	deferred  bool
+	responded bool
+}
+
+func newMessageContext(s *discordgo.Session, m *discordgo.MessageCreate) *CommandContext {
+	return &CommandContext{
+		Session:     s,
+		GuildID:     m.GuildID,
+		ChannelID:   m.ChannelID,
+		Author:      m.Author,
+		Member:      m.Member,
+		Attachments: m.Attachments,
+	}
+}
+
+func newInteractionContext(s *discordgo.Session, i *discordgo.InteractionCreate) *CommandContext {
+	ctx := &CommandContext{
+		Session:     s,
+		GuildID:     i.GuildID,
+		ChannelID:   i.ChannelID,
+		Member:      i.Member,
+		Interaction: i.Interaction,
+	}
+	if i.Member != nil {
+		ctx.Author = i.Member.User
+	} else {
+		ctx.Author = i.User
+	}
+	if i.Type == discordgo.InteractionApplicationCommand {
+		if resolved := i.ApplicationCommandData().Resolved; resolved != nil {
+			for _, attachment := range resolved.Attachments {
+				ctx.Attachments = append(ctx.Attachments, attachment)
+			}
+		}
+	}
+	return ctx
+}
+
+// ReplyUsage answers with the usage error of the running command.
+func (c *CommandContext) ReplyUsage() {
+	if c.Command != nil {
+		c.Reply(c.Command.UsageError())
+	}
+}
+
+// Defer acknowledges a slash command so slow work (Google Sheets, member
+// fetches) does not hit Discord's three second response limit. For prefix
+// commands it shows the typing indicator instead.
+func (c *CommandContext) Defer() {
+	if c.Interaction == nil {
+		c.Session.ChannelTyping(c.ChannelID)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deferred || c.responded {
+		return
+	}
+	err := c.Session.InteractionRespond(c.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Printf("Failed to defer interaction: %v", err)
+		return
+	}
+	c.deferred = true
+}
+
+// Reply answers the command. The first reply to an interaction becomes the
+// interaction response; later replies are sent as follow-ups, falling back to
+// a plain channel message once the interaction token has expired.
+func (c *CommandContext) Reply(content string) *discordgo.Message {
+	return c.reply(content, nil, nil)
+}
+
+// ReplyEmbed is Reply for embeds.
+func (c *CommandContext) ReplyEmbed(embed *discordgo.MessageEmbed) *discordgo.Message {
+	return c.reply("", embed, nil)
+}
+
+// pagedEmbeds splits lines into green embeds of perPage lines each, numbering
+// the pages when there is more than one.
+func pagedEmbeds(title string, lines []string, perPage int) []*discordgo.MessageEmbed {
+	pages := max((len(lines)+perPage-1)/perPage, 1)
+	embeds := make([]*discordgo.MessageEmbed, pages)
+	for page := range embeds {
+		end := min((page+1)*perPage, len(lines))
+		embeds[page] = &discordgo.MessageEmbed{
+			Title:       title,
+			Description: strings.Join(lines[page*perPage:end], "\n"),
+			Color:       0x00ff00, // Green color
+		}
+		if pages > 1 {
+			embeds[page].Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Page %d of %d", page+1, pages)}
+		}
+	}
+	return embeds
+}
+
+// ReplyFile is Reply with a file attached.
+func (c *CommandContext) ReplyFile(content string, file *Attachment) *discordgo.Message {
+	return c.reply(content, nil, file)
+}
+
+// EditReply replaces the content of a message previously returned by Reply.
+// For interactions the original response is edited.
+func (c *CommandContext) EditReply(msg *discordgo.Message, content string) {
+	if c.Interaction == nil {
+		if msg != nil {
+			c.Session.ChannelMessageEdit(c.ChannelID, msg.ID, content)
+		}
+		return
+	}
+	c.Session.InteractionResponseEdit(c.Interaction, &discordgo.WebhookEdit{Content: &content})
+}
+
+func (c *CommandContext) reply(content string, embed *discordgo.MessageEmbed, file *Attachment) *discordgo.Message {
+	var embeds []*discordgo.MessageEmbed
+	if embed != nil {
+		embeds = []*discordgo.MessageEmbed{embed}
+	}
+	// Readers are consumed by a send, so every attempt gets fresh ones.
+	files := func() []*discordgo.File {
+		if file == nil {
+			return nil
+		}
+		return []*discordgo.File{file.file()}
+	}
+
+	if c.Interaction == nil {
+		return c.sendToChannel(content, embed, files())
+	}
+	if msg, ok := c.replyToInteraction(content, embeds, files); ok {
+		return msg
+	}
+	return c.sendToChannel(content, embed, files())
+}
+
+// replyToInteraction sends the reply through the interaction and reports
+// whether that worked. The lock makes sure only one reply becomes the initial
+// response.
+func (c *CommandContext) replyToInteraction(content string, embeds []*discordgo.MessageEmbed, files func() []*discordgo.File) (*discordgo.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case !c.responded && !c.deferred:
+		err := c.Session.InteractionRespond(c.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Content: content, Embeds: embeds, Files: files()},
+		})
+		if err == nil {
+			c.responded = true
+			return nil, true
+		}
+		log.Printf("Failed to respond to interaction: %v", err)
+	case !c.responded:
+		edit := &discordgo.WebhookEdit{Content: &content, Files: files()}
+		if embeds != nil {
+			edit.Embeds = &embeds
+		}
+		msg, err := c.Session.InteractionResponseEdit(c.Interaction, edit)
+		if err == nil {
+			c.responded = true
+			return msg, true
+		}
+		log.Printf("Failed to edit deferred interaction response: %v", err)
+	default:
+		msg, err := c.Session.FollowupMessageCreate(c.Interaction, true, &discordgo.WebhookParams{
+			Content: content,
+			Embeds:  embeds,
+			Files:   files(),
+		})
+		if err == nil {
+			return msg, true
+		}
+		log.Printf("Failed to send interaction follow-up: %v", err)
+	}
+	return nil, false
+}
+
+func (c *CommandContext) sendToChannel(content string, embed *discordgo.MessageEmbed, files []*discordgo.File) *discordgo.Message {
+	var msg *discordgo.Message
+	var err error
+	if embed != nil || files != nil {
+		msg, err = c.Session.ChannelMessageSendComplex(c.ChannelID, &discordgo.MessageSend{Content: content, Embed: embed, Files: files})
+	} else {
+		msg, err = c.Session.ChannelMessageSend(c.ChannelID, content)
+	}
+	if err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+	return msg
+}
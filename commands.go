@@ -0,0 +1,1432 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ==================================COMMAND REGISTRY===========================================
+const commandPrefix = "!"
+
+// SlashOptions maps option names to the values of a slash command invocation.
+type SlashOptions map[string]*discordgo.ApplicationCommandInteractionDataOption
+
+// Command describes a bot command once; the prefix dispatcher, the slash
+// command registration, usage errors and `!help` are all generated from it.
+type Command struct {
+	Name        string
+	Aliases     []string
+	Usage       string // Arguments shown after the command name, e.g. "[time]"
+	Description string
+	Details     string
+	Examples    []string
+	// Permission is the access level a member needs to run the command.
+	Permission PermissionLevel
+	// MinArgs is the number of prefix arguments required before Handler runs.
+	MinArgs int
+
+	// Handler runs the prefix form with the whitespace separated arguments.
+	Handler func(ctx *CommandContext, args []string)
+
+	// Options and SlashHandler describe the slash command form. Commands
+	// without a SlashHandler are prefix-only.
+	Options      []*discordgo.ApplicationCommandOption
+	SlashHandler func(ctx *CommandContext, options SlashOptions)
+}
+
+// UsageLine returns the full prefix syntax, e.g. "!setclasstime [time]".
+func (c *Command) UsageLine() string {
+	if c.Usage == "" {
+		return commandPrefix + c.Name
+	}
+	return commandPrefix + c.Name + " " + c.Usage
+}
+
+// UsageError returns the message shown when the command is called wrongly.
+func (c *Command) UsageError() string {
+	msg := fmt.Sprintf("Usage: `%s`", c.UsageLine())
+	for _, alias := range c.Aliases {
+		msg += fmt.Sprintf(" or `%s%s`", commandPrefix, alias)
+	}
+	return msg + "\nMore detail use `!help " + c.Name + "`"
+}
+
+// HelpText returns the detailed help for the command.
+func (c *Command) HelpText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s**\n%s\n", c.UsageLine(), c.Description)
+	if c.Details != "" {
+		b.WriteString(c.Details + "\n")
+	}
+	if len(c.Aliases) > 0 {
+		aliases := make([]string, len(c.Aliases))
+		for i, alias := range c.Aliases {
+			aliases[i] = "`" + commandPrefix + alias + "`"
+		}
+		fmt.Fprintf(&b, "Aliases: %s\n", strings.Join(aliases, ", "))
+	}
+	if c.Permission != PermissionEveryone {
+		fmt.Fprintf(&b, "Requires %s access.\n", c.Permission)
+	}
+	for _, example := range c.Examples {
+		fmt.Fprintf(&b, "Example: `%s`\n", example)
+	}
+	return b.String()
+}
+
+var (
+	commands     []*Command
+	commandIndex = make(map[string]*Command)
+)
+
+// registerCommand adds cmd to the registry under its name and aliases.
+func registerCommand(cmd *Command) {
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		key := strings.ToLower(name)
+		if existing, ok := commandIndex[key]; ok {
+			panic(fmt.Sprintf("command name %q used by both %s and %s", name, existing.Name, cmd.Name))
+		}
+		commandIndex[key] = cmd
+	}
+	commands = append(commands, cmd)
+}
+
+// lookupCommand resolves a command name or alias exactly, ignoring case and
+// a leading "!" or "/".
+func lookupCommand(name string) (*Command, bool) {
+	name = strings.TrimLeft(strings.ToLower(name), commandPrefix+"/")
+	cmd, ok := commandIndex[name]
+	return cmd, ok
+}
+
+// splitSettings separates key=value arguments from the remaining words. Keys
+// are lower-cased.
+func splitSettings(args []string) (map[string]string, []string) {
+	settings := make(map[string]string)
+	var words []string
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			words = append(words, arg)
+			continue
+		}
+		settings[strings.ToLower(key)] = value
+	}
+	return settings, words
+}
+
+// dispatchPrefixCommand runs the command in content, if any.
+func dispatchPrefixCommand(ctx *CommandContext, content string) {
+	if !strings.HasPrefix(content, commandPrefix) {
+		return
+	}
+
+	content = strings.TrimSpace(content)
+	args := strings.Fields(content)
+	if len(args) == 0 {
+		return
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		return
+	}
+
+	ctx.Command = cmd
+	ctx.RawArgs = strings.TrimSpace(content[len(args[0]):])
+	args = args[1:]
+
+	if !hasCommandPermission(ctx, cmd) {
+		ctx.Reply(permissionDenied(ctx, cmd))
+		return
+	}
+	if len(args) < cmd.MinArgs {
+		ctx.Reply(cmd.UsageError())
+		return
+	}
+	cmd.Handler(ctx, args)
+}
+
+// dispatchSlashCommand runs the registered command for an interaction.
+func dispatchSlashCommand(ctx *CommandContext, data discordgo.ApplicationCommandInteractionData) {
+	cmd, ok := lookupCommand(data.Name)
+	if !ok || cmd.SlashHandler == nil {
+		log.Printf("Unknown slash command: %s", data.Name)
+		ctx.Reply("Unknown command.")
+		return
+	}
+
+	ctx.Command = cmd
+	if !hasCommandPermission(ctx, cmd) {
+		ctx.Reply(permissionDenied(ctx, cmd))
+		return
+	}
+	cmd.SlashHandler(ctx, optionMap(data.Options))
+}
+
+// applicationCommands builds the slash command definitions from the registry.
+func applicationCommands() []*discordgo.ApplicationCommand {
+	dmPermission := false
+
+	var appCommands []*discordgo.ApplicationCommand
+	for _, cmd := range commands {
+		if cmd.SlashHandler == nil {
+			continue
+		}
+		appCmd := &discordgo.ApplicationCommand{
+			Name:         cmd.Name,
+			Description:  cmd.Description,
+			Options:      cmd.Options,
+			DMPermission: &dmPermission,
+		}
+		if cmd.Permission == PermissionAdmin {
+			perm := int64(discordgo.PermissionManageServer)
+			appCmd.DefaultMemberPermissions = &perm
+		}
+		appCommands = append(appCommands, appCmd)
+	}
+	return appCommands
+}
+
+// ==================================COMMAND DECLARATIONS===========================================
+func init() {
+	registerCommand(&Command{
+		Name:        "help",
+		Usage:       "[command]",
+		Description: "Show the available commands",
+		Details:     "Add a command name or alias to see its details.",
+		Examples:    []string{"!help", "!help ms"},
+		Handler:     func(ctx *CommandContext, args []string) { HelpCommand(ctx, args) },
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "command",
+				Description: "Command to show details for",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			var args []string
+			if opt, ok := options["command"]; ok {
+				args = []string{opt.StringValue()}
+			}
+			HelpCommand(ctx, args)
+		},
+	})
+
+	registerCommand(&Command{
+		Name:         "ping",
+		Description:  "Check bot's response time",
+		Handler:      func(ctx *CommandContext, args []string) { handlePing(ctx) },
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) { handlePing(ctx) },
+	})
+
+	registerCommand(&Command{
+		Name:        "marklistnow",
+		Aliases:     []string{"mn"},
+		Usage:       "[voice channel name] [time]",
+		Description: "Create list of users in a voice channel at a specific time",
+		Permission:  PermissionTA,
+		Details: "Lists everyone who was in the channel during the class that starts at the given time, " +
+			"with their minutes present and when they first joined.",
+		Examples: []string{"!marklistnow backend 08:45"},
+		MinArgs:  2,
+		Handler: func(ctx *CommandContext, args []string) {
+			handleMarkListNow(ctx, args[0], args[1])
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         "channel",
+				Description:  "Voice channel to check",
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice},
+				Required:     true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "time",
+				Description: "Class time as HH:MM",
+				Required:    true,
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			channel := options["channel"].ChannelValue(ctx.Session)
+			if channel == nil {
+				ctx.Reply("Voice channel not found.")
+				return
+			}
+			handleMarkListNow(ctx, channel.Name, options["time"].StringValue())
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "marksheet",
+		Aliases:     []string{"ms"},
+		Usage:       "[now] [Sheet Name] | stop [Sheet Name] | status",
+		Description: "Manage attendance in a Google Sheet",
+		Permission:  PermissionTeacher,
+		Details: "Create or Update Attendance in a Google Sheet. If the sheet is not available, a new one will be created. Can handle multi-word sheet names.\n" +
+			"Add `now` before [Sheet Name] to use current Time. Running it again for the same sheet restarts its updates.\n" +
+			"Use `stop` to stop attendance updates (all sheets, or only the named one) and `status` to see which sheets are being updated.",
+		Examples: []string{"!marksheet Class A", "!ms now Class A", "!ms stop", "!ms stop Class A", "!ms status"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			switch {
+			case args[0] == "stop":
+				handleMarkSheetStop(ctx, strings.Join(args[1:], " "))
+			case len(args) == 1 && args[0] == "status":
+				handleMarkSheetStatus(ctx)
+			case args[0] != "now":
+				handleMarkSheet(ctx, strings.Join(args, " "), false)
+			case len(args) >= 2:
+				handleMarkSheet(ctx, strings.Join(args[1:], " "), true)
+			default:
+				ctx.ReplyUsage()
+			}
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "start",
+				Description: "Create or update an attendance sheet",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "sheet",
+						Description: "Sheet name",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionBoolean,
+						Name:        "now",
+						Description: "Use the current time as the class time",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "stop",
+				Description: "Stop attendance updates",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "sheet",
+						Description: "Only stop this sheet",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "status",
+				Description: "Show which sheets are being updated",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			if sub, ok := options["start"]; ok {
+				subOptions := optionMap(sub.Options)
+				now := false
+				if opt, ok := subOptions["now"]; ok {
+					now = opt.BoolValue()
+				}
+				handleMarkSheet(ctx, subOptions["sheet"].StringValue(), now)
+			} else if sub, ok := options["stop"]; ok {
+				handleMarkSheetStop(ctx, optionMap(sub.Options).String("sheet"))
+			} else if _, ok := options["status"]; ok {
+				handleMarkSheetStatus(ctx)
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "setstudent",
+		Usage:       "[role name]",
+		Description: "Add students with a specific role to the database",
+		Permission:  PermissionTeacher,
+		Examples:    []string{"!setstudent student"},
+		MinArgs:     1,
+		Handler: func(ctx *CommandContext, args []string) {
+			role, err := findRoleByName(ctx.Session, ctx.GuildID, strings.Join(args, " "))
+			if err != nil {
+				ctx.Reply("Role not found.")
+				return
+			}
+			handleSetStudent(ctx, guildDB(ctx.GuildID), role)
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "role",
+				Description: "Student role",
+				Required:    true,
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			role := options["role"].RoleValue(ctx.Session, ctx.GuildID)
+			if role == nil || role.Name == "" {
+				ctx.Reply("Role not found.")
+				return
+			}
+			handleSetStudent(ctx, guildDB(ctx.GuildID), role)
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "student",
+		Usage:       "add [user] [real name] [number=N] [section=S] | remove [user] | purge [user] | rename [user] [real name] | list [section] | import (attach a CSV) | export",
+		Description: "Manage the student roster",
+		Permission:  PermissionTeacher,
+		Details: "Users can be given as a mention, an ID or a username. `import` reads an attached CSV with one student per line: " +
+			"Discord ID or username, real name, student number, section. A header row like the one written by `export` is also understood. " +
+			"Details left empty keep their stored value. `remove` deactivates a student and ends their enrollments but keeps their history; " +
+			"`purge` deletes the student and their enrollments.",
+		Examples: []string{"!student add @alice Alice Smith number=6401234 section=A", "!student rename @alice Alice Jones", "!student list A", "!student import"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			switch {
+			case args[0] == "add" && len(args) >= 2:
+				settings, words := splitSettings(args[2:])
+				handleStudentAdd(ctx, args[1], Student{
+					RealName:      strings.Join(words, " "),
+					StudentNumber: settings["number"],
+					Section:       settings["section"],
+				})
+			case args[0] == "remove" && len(args) >= 2:
+				handleStudentRemove(ctx, strings.Join(args[1:], " "))
+			case args[0] == "purge" && len(args) >= 2:
+				handleStudentPurge(ctx, strings.Join(args[1:], " "))
+			case args[0] == "rename" && len(args) >= 3:
+				handleStudentRename(ctx, args[1], strings.Join(args[2:], " "))
+			case args[0] == "list":
+				handleStudentList(ctx, strings.Join(args[1:], " "))
+			case args[0] == "import":
+				handleStudentImport(ctx)
+			case args[0] == "export":
+				handleStudentExport(ctx)
+			default:
+				ctx.ReplyUsage()
+			}
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Add a student or update their details",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "The student", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "real_name", Description: "Real name"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "number", Description: "Student number"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "section", Description: "Section"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Remove a student, keeping their attendance history",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "The student", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "purge",
+				Description: "Delete a student and their enrollments",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "The student", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "rename",
+				Description: "Change a student's real name",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "The student", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "real_name", Description: "Real name", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show the students",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "section", Description: "Only this section"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "import",
+				Description: "Add or update students from a CSV file",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionAttachment, Name: "file", Description: "CSV: Discord ID or username, real name, student number, section", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "export",
+				Description: "Download the students as a CSV file",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				user := "" // As a mention, like the prefix form accepts
+				if opt, ok := subOptions["user"]; ok {
+					user = "<@" + opt.UserValue(nil).ID + ">"
+				}
+				switch name {
+				case "add":
+					handleStudentAdd(ctx, user, Student{
+						RealName:      subOptions.String("real_name"),
+						StudentNumber: subOptions.String("number"),
+						Section:       subOptions.String("section"),
+					})
+				case "remove":
+					handleStudentRemove(ctx, user)
+				case "purge":
+					handleStudentPurge(ctx, user)
+				case "rename":
+					handleStudentRename(ctx, user, subOptions.String("real_name"))
+				case "list":
+					handleStudentList(ctx, subOptions.String("section"))
+				case "import":
+					handleStudentImport(ctx)
+				case "export":
+					handleStudentExport(ctx)
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "rosterrole",
+		Usage:       "add [role name] | remove [role name] | list | channel [#channel|off]",
+		Description: "Keep the students in sync with roles",
+		Permission:  PermissionTeacher,
+		Details: "Members who gain a roster role become students and those who lose every roster role, or leave the server, are deactivated. " +
+			"Each enrollment's start and end is recorded. Changes are summarised in the channel set with `channel`. " +
+			"`!setstudent` also makes its role a roster role.",
+		Examples: []string{"!rosterrole add student", "!rosterrole channel #teachers", "!rosterrole list"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			switch {
+			case args[0] == "list":
+				handleRosterRoleList(ctx)
+			case args[0] == "channel" && len(args) == 2:
+				channelID := ""
+				if args[1] != "off" {
+					channelID = strings.TrimSuffix(strings.TrimPrefix(args[1], "<#"), ">")
+					if !isSnowflake(channelID) {
+						ctx.Reply("Mention a channel, e.g. `#teachers`, or use `off`.")
+						return
+					}
+				}
+				handleRosterChannel(ctx, channelID)
+			case (args[0] == "add" || args[0] == "remove") && len(args) >= 2:
+				role, err := findRoleByName(ctx.Session, ctx.GuildID, strings.Join(args[1:], " "))
+				if err != nil {
+					ctx.Reply("Role not found.")
+					return
+				}
+				handleRosterRole(ctx, args[0], role)
+			default:
+				ctx.ReplyUsage()
+			}
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Make members with a role students",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionRole, Name: "role", Description: "Roster role", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Stop syncing a role",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionRole, Name: "role", Description: "Roster role", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show the roster roles and summary channel",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "channel",
+				Description: "Set where roster changes are posted",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionChannel, Name: "channel", Description: "Leave out to stop posting"},
+				},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				switch name {
+				case "add", "remove":
+					role := subOptions["role"].RoleValue(ctx.Session, ctx.GuildID)
+					if role == nil || role.Name == "" {
+						ctx.Reply("Role not found.")
+						return
+					}
+					handleRosterRole(ctx, name, role)
+				case "list":
+					handleRosterRoleList(ctx)
+				case "channel":
+					channelID := ""
+					if opt, ok := subOptions["channel"]; ok {
+						channelID = opt.ChannelValue(nil).ID
+					}
+					handleRosterChannel(ctx, channelID)
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "report",
+		Usage:       "student [user] [from] [to] | class [class name] [from] [to] | today",
+		Description: "Show attendance reports",
+		Details: "Dates are YYYY-MM-DD; with one date the report runs from it until today. " +
+			"`class` and `today` count each student only in the sessions they were enrolled for. " +
+			"Everyone may see their own `student` report. Reports on other students, `class` and `today` need ta access.",
+		Examples: []string{"!report student", "!report student @alice 2026-09-01 2026-09-30", "!report class Class A 2026-09-01", "!report today"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			words, from, to, err := splitReportDates(args[1:])
+			if err != nil {
+				ctx.Reply(err.Error() + ".")
+				return
+			}
+			switch {
+			case args[0] == "student":
+				handleReportStudent(ctx, strings.Join(words, " "), from, to)
+			case args[0] == "class" && len(words) > 0:
+				if !hasPermission(ctx, PermissionTA) {
+					ctx.Reply(fmt.Sprintf("You need %s access for this report.", PermissionTA))
+					return
+				}
+				handleReportClass(ctx, strings.Join(words, " "), from, to)
+			case args[0] == "today" && len(args) == 1:
+				if !hasPermission(ctx, PermissionTA) {
+					ctx.Reply(fmt.Sprintf("You need %s access for this report.", PermissionTA))
+					return
+				}
+				handleReportToday(ctx)
+			default:
+				ctx.ReplyUsage()
+			}
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "student",
+				Description: "Attendance of one student",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "Leave out for yourself"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "First date, YYYY-MM-DD"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "to", Description: "Last date, YYYY-MM-DD"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "class",
+				Description: "Attendance of every student in a class",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "class", Description: "Class name", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "First date, YYYY-MM-DD"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "to", Description: "Last date, YYYY-MM-DD"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "today",
+				Description: "Attendance in today's classes",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				from, to := subOptions.String("from"), subOptions.String("to")
+				if err := checkReportRange(from, to); err != nil {
+					ctx.Reply(err.Error() + ".")
+					return
+				}
+				switch name {
+				case "student":
+					user := ""
+					if opt, ok := subOptions["user"]; ok {
+						user = "<@" + opt.UserValue(nil).ID + ">"
+					}
+					handleReportStudent(ctx, user, from, to)
+				case "class", "today":
+					if !hasPermission(ctx, PermissionTA) {
+						ctx.Reply(fmt.Sprintf("You need %s access for this report.", PermissionTA))
+						return
+					}
+					if name == "class" {
+						handleReportClass(ctx, subOptions.String("class"), from, to)
+					} else {
+						handleReportToday(ctx)
+					}
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "export",
+		Usage:       "[class name] [from] [to] [csv|xlsx|json]",
+		Description: "Download a class's attendance for a date range",
+		Permission:  PermissionTeacher,
+		Details: "Builds a table with one row per student and one column per recorded session between the two dates " +
+			"(YYYY-MM-DD, inclusive), followed by each student's totals. Students appear if they were enrolled for any of the sessions; " +
+			"sessions they were not enrolled for are left blank. The file is made by the bot, so it works with any exporter. " +
+			"The format defaults to csv.",
+		Examples: []string{"!export Class A 2026-09-01 2026-09-30", "!export Class A 2026-09-01 2026-12-20 xlsx"},
+		MinArgs:  3,
+		Handler: func(ctx *CommandContext, args []string) {
+			className, from, to, format, err := parseExportArgs(args)
+			if err != nil {
+				ctx.Reply(err.Error() + ".\n" + ctx.Command.UsageError())
+				return
+			}
+			handleExport(ctx, className, from, to, format)
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "class", Description: "Class name", Required: true},
+			{Type: discordgo.ApplicationCommandOptionString, Name: "from", Description: "First date, YYYY-MM-DD", Required: true},
+			{Type: discordgo.ApplicationCommandOptionString, Name: "to", Description: "Last date, YYYY-MM-DD", Required: true},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "format",
+				Description: "File format, csv by default",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "CSV", Value: exportCSV},
+					{Name: "Excel (xlsx)", Value: exportXLSX},
+					{Name: "JSON", Value: exportJSON},
+				},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			format := options.String("format")
+			if format == "" {
+				format = exportCSV
+			}
+			handleExport(ctx, options.String("class"), options.String("from"), options.String("to"), format)
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "setclasstime",
+		Usage:       "[time] [class name] [days=mon,wed] [duration=90m] [tz=Area/City] [channel=voice channel]",
+		Description: "Set the class time",
+		Permission:  PermissionTeacher,
+		Details: "Set the class Time format HH:MM. A guild can have several classes; without a name the class is called `default`.\n" +
+			"Running it again for an existing class only changes the settings you give.",
+		Examples: []string{"!setclasstime 08:45", "!setclasstime 13:00 Class A days=mon,thu duration=2h tz=Asia/Tokyo channel=backend"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			opts, err := parseClassTimeArgs(args[1:])
+			if err != nil {
+				ctx.Reply(err.Error() + "\n" + ctx.Command.UsageError())
+				return
+			}
+			setClassTime(ctx, args[0], opts)
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "time",
+				Description: "Class time as HH:MM",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "class",
+				Description: "Class name",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "days",
+				Description: "Weekdays, e.g. mon,wed,fri (default every day)",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "duration",
+				Description: "Class length, e.g. 90m",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "timezone",
+				Description: "IANA timezone, e.g. Asia/Bangkok (default the server's)",
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         "channel",
+				Description:  "Voice channel the class meets in",
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			opts := ClassTimeOptions{
+				Name:     options.String("class"),
+				Days:     options.String("days"),
+				Duration: options.String("duration"),
+				Timezone: options.String("timezone"),
+			}
+			if opt, ok := options["channel"]; ok {
+				if channel := opt.ChannelValue(ctx.Session); channel != nil {
+					opts.VoiceChannel = channel.Name
+				}
+			}
+			setClassTime(ctx, options.String("time"), opts)
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "classtime",
+		Usage:       "[class name]",
+		Description: "Show the set class times",
+		Examples:    []string{"!classtime", "!classtime Class A"},
+		Handler: func(ctx *CommandContext, args []string) {
+			showClassTime(ctx, strings.Join(args, " "))
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "class",
+				Description: "Class name",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			showClassTime(ctx, options.String("class"))
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "delclasstime",
+		Usage:       "[class name | time]",
+		Description: "Delete a set class time",
+		Permission:  PermissionTeacher,
+		Details:     "Give a class name, or a time to delete every class starting then.",
+		Examples:    []string{"!delclasstime Class A", "!delclasstime 08:45"},
+		MinArgs:     1,
+		Handler: func(ctx *CommandContext, args []string) {
+			deleteClassTime(ctx, strings.Join(args, " "))
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "class",
+				Description: "Class name or HH:MM start time",
+				Required:    true,
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			deleteClassTime(ctx, options.String("class"))
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "timezone",
+		Usage:       "[Area/City]",
+		Description: "Show or set the server's timezone",
+		Permission:  PermissionTeacher,
+		Details:     "Times given to commands are read in this timezone. Classes can override it with `tz=` on `!setclasstime`.",
+		Examples:    []string{"!timezone", "!timezone Asia/Bangkok"},
+		Handler: func(ctx *CommandContext, args []string) {
+			handleTimezone(ctx, strings.Join(args, " "))
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "timezone",
+				Description: "IANA timezone, e.g. Asia/Bangkok",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			handleTimezone(ctx, options.String("timezone"))
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "policy",
+		Usage:       "[class name] [setting=value ...] | reset [class name]",
+		Description: "Show or change the attendance rules",
+		Permission:  PermissionTeacher,
+		Details: "Without a class name the guild default is used. Settings: `prewindow=10m`, `late=10m`, `minpresence=50`, " +
+			"`earlyleave=15m` (0 turns it off) and the labels `present=X`, `latelabel=L`, `absent=A`, `earlylabel=E`.",
+		Examples: []string{"!policy", "!policy Class A late=15m minpresence=60", "!policy reset Class A"},
+		Handler: func(ctx *CommandContext, args []string) {
+			if len(args) > 0 && args[0] == "reset" {
+				handlePolicyReset(ctx, strings.Join(args[1:], " "))
+				return
+			}
+			settings, words := splitSettings(args)
+			handlePolicy(ctx, strings.Join(words, " "), settings)
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Show the attendance rules",
+				Options:     []*discordgo.ApplicationCommandOption{policyClassOption()},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Change the attendance rules",
+				Options: []*discordgo.ApplicationCommandOption{
+					policyClassOption(),
+					{Type: discordgo.ApplicationCommandOptionString, Name: "prewindow", Description: "How early joining counts, e.g. 10m"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "late", Description: "Grace period before a join is late, e.g. 10m"},
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "minpresence", Description: "Minimum % of the class to not be absent"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "earlyleave", Description: "Leaving this long before the end is marked, 0 for off"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "present", Description: "Present label"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "latelabel", Description: "Late label"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "absent", Description: "Absent label"},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "earlylabel", Description: "Early leave label"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Go back to the default attendance rules",
+				Options:     []*discordgo.ApplicationCommandOption{policyClassOption()},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				className := subOptions.String("class")
+				switch name {
+				case "show":
+					handlePolicy(ctx, className, nil)
+				case "reset":
+					handlePolicyReset(ctx, className)
+				case "set":
+					settings := make(map[string]string)
+					for key, opt := range subOptions {
+						if key != "class" {
+							settings[key] = fmt.Sprint(opt.Value)
+						}
+					}
+					handlePolicy(ctx, className, settings)
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "reacrole",
+		Usage:       "[role name] [message] | list | delete [message id] [emoji]",
+		Description: "Post a message that assigns a role to everyone who reacts",
+		Permission:  PermissionTeacher,
+		Details: "Create a message that will be sent and reacted to by users. All users who react will be assigned the specified role.\n" +
+			"Use `list` to see every reaction role and `delete` to remove the ones on a message.",
+		Examples: []string{"!reacrole 4year For 4th year students! Leave a reaction below!", "!reacrole list", "!reacrole delete 123456789012345678"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			switch {
+			case args[0] == "list" && len(args) == 1:
+				handleReactionRoleList(ctx)
+				return
+			case args[0] == "delete" && (len(args) == 2 || len(args) == 3):
+				emoji := ""
+				if len(args) == 3 {
+					emoji = args[2]
+				}
+				handleReactionRoleDelete(ctx, args[1], emoji)
+				return
+			case len(args) < 2:
+				ctx.ReplyUsage()
+				return
+			}
+
+			roleID, err := findRoleByNameReac(ctx.Session, ctx.GuildID, args[0])
+			if err != nil {
+				ctx.Reply(fmt.Sprintf("Role '%s' not found: %v", args[0], err))
+				return
+			}
+			message := strings.TrimSpace(ctx.RawArgs[len(args[0]):]) // Get everything after the role name as the message
+			handleReactionRoleCreate(ctx, roleID, message)
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Post a reaction role message",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Name:        "role",
+						Description: "Role to assign",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message",
+						Description: "Message to post",
+						Required:    true,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show every reaction role",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "delete",
+				Description: "Remove the reaction roles of a message",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message_id",
+						Description: "ID of the reaction role message",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "emoji",
+						Description: "Only remove this emoji",
+					},
+				},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				switch name {
+				case "create":
+					role := subOptions["role"].RoleValue(ctx.Session, ctx.GuildID)
+					handleReactionRoleCreate(ctx, role.ID, subOptions.String("message"))
+				case "list":
+					handleReactionRoleList(ctx)
+				case "delete":
+					handleReactionRoleDelete(ctx, subOptions.String("message_id"), subOptions.String("emoji"))
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "reacmenu",
+		Usage:       "[normal|exclusive|verify] [max=N] [message] + one `emoji role name` per line | add [message id] [emoji] [role name]",
+		Description: "Post a menu that maps several emojis to roles",
+		Permission:  PermissionTeacher,
+		Details: "Each line after the first binds an emoji (custom server emojis work too) to a role.\n" +
+			"`exclusive` menus let members hold only one of the roles, `max=N` limits how many they can pick and " +
+			"`verify` menus add the role but never remove it. Use `!reacrole list` and `!reacrole delete` to manage menus.",
+		Examples: []string{"!reacmenu exclusive Pick your year!\n1️⃣ 1st Year\n2️⃣ 2nd Year", "!reacmenu add 123456789012345678 🎮 Gamers"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			if args[0] == "add" {
+				if len(args) < 4 {
+					ctx.ReplyUsage()
+					return
+				}
+				entry := MenuEntry{Emoji: normalizeEmoji(args[2]), RoleName: strings.Join(args[3:], " ")}
+				handleReactionMenuAdd(ctx, args[1], entry)
+				return
+			}
+
+			mode, maxRoles, message, entries, err := parseReactionMenuArgs(ctx.RawArgs)
+			if err != nil {
+				ctx.Reply(err.Error())
+				return
+			}
+			handleReactionMenuCreate(ctx, mode, maxRoles, message, entries)
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Post a reaction role menu",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "roles",
+						Description: "Emoji and role pairs separated by ;, e.g. 1️⃣ 1st Year; 2️⃣ 2nd Year",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message",
+						Description: "Message to post",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "mode",
+						Description: "How the roles combine",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "normal", Value: MenuModeNormal},
+							{Name: "exclusive", Value: MenuModeExclusive},
+							{Name: "verify", Value: MenuModeVerify},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "max",
+						Description: "Most roles a member can pick (0 for no limit)",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Add an emoji to an existing menu",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message_id",
+						Description: "ID of the menu message",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "emoji",
+						Description: "Emoji to react with",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Name:        "role",
+						Description: "Role to assign",
+						Required:    true,
+					},
+				},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				switch name {
+				case "create":
+					entries, err := parseMenuEntries(strings.Split(subOptions.String("roles"), ";"))
+					if err != nil {
+						ctx.Reply(err.Error())
+						return
+					}
+					handleReactionMenuCreate(ctx, subOptions.String("mode"), subOptions.Int("max"), subOptions.String("message"), entries)
+				case "add":
+					entry := MenuEntry{
+						Emoji:  normalizeEmoji(subOptions.String("emoji")),
+						RoleID: subOptions["role"].RoleValue(nil, ctx.GuildID).ID,
+					}
+					handleReactionMenuAdd(ctx, subOptions.String("message_id"), entry)
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "rolepanel",
+		Usage:       "create [buttons|select] [max=N] [title] + one role per line | add|remove [message id] [role] | title [message id] [text] | max [message id] [N] | list | delete [message id]",
+		Description: "Post a panel of buttons or a select menu that assigns roles",
+		Permission:  PermissionTeacher,
+		Details: "Each line after the first names a role, optionally prefixed by an emoji. Buttons toggle one role each; " +
+			"the select menu toggles every role picked at once, and members can hold at most `max` of its roles. Members get a private confirmation.",
+		Examples: []string{"!rolepanel create select max=2 Pick your sections\nSection A\n🎮 Section B", "!rolepanel add 123456789012345678 Section C", "!rolepanel list"},
+		MinArgs:  1,
+		Handler: func(ctx *CommandContext, args []string) {
+			// Everything after the first n words, including the following lines.
+			rest := func(n int) string {
+				raw := ctx.RawArgs
+				for i := 0; i < n; i++ {
+					raw = strings.TrimLeft(raw, " \t\n")
+					raw = raw[len(strings.Fields(raw)[0]):]
+				}
+				// Keep a leading newline so "create select\nRole" has no title.
+				return strings.TrimRight(strings.TrimLeft(raw, " \t"), " \t\n")
+			}
+
+			switch {
+			case args[0] == "list":
+				handleRolePanelList(ctx)
+			case args[0] == "delete" && len(args) == 2:
+				handleRolePanelDelete(ctx, args[1])
+			case args[0] == "create" && len(args) >= 2:
+				lines := strings.Split(rest(2), "\n")
+				settings, words := splitSettings(strings.Fields(lines[0]))
+				maxRoles := 0
+				if value, ok := settings["max"]; ok {
+					var err error
+					if maxRoles, err = strconv.Atoi(value); err != nil || maxRoles < 0 {
+						ctx.Reply("Invalid max, use e.g. `max=2`.")
+						return
+					}
+				}
+				roles, err := parsePanelRoles(ctx.Session, ctx.GuildID, lines[1:])
+				if err != nil {
+					ctx.Reply(err.Error())
+					return
+				}
+				handleRolePanelCreate(ctx, strings.ToLower(args[1]), strings.Join(words, " "), maxRoles, roles)
+			case args[0] == "add" && len(args) >= 3:
+				roles, err := parsePanelRoles(ctx.Session, ctx.GuildID, strings.Split(rest(2), "\n"))
+				if err != nil {
+					ctx.Reply(err.Error())
+					return
+				}
+				handleRolePanelEdit(ctx, args[1], func(panel *RolePanel) error { return addPanelRoles(panel, roles) })
+			case args[0] == "remove" && len(args) >= 3:
+				roleID, err := findRoleByNameReac(ctx.Session, ctx.GuildID, rest(2))
+				if err != nil {
+					ctx.Reply(fmt.Sprintf("Role '%s' not found: %v", rest(2), err))
+					return
+				}
+				handleRolePanelEdit(ctx, args[1], func(panel *RolePanel) error { return removePanelRole(panel, roleID) })
+			case args[0] == "title" && len(args) >= 3:
+				title := rest(2)
+				handleRolePanelEdit(ctx, args[1], func(panel *RolePanel) error {
+					panel.Title = title
+					return nil
+				})
+			case args[0] == "max" && len(args) == 3:
+				maxRoles, err := strconv.Atoi(args[2])
+				if err != nil || maxRoles < 0 {
+					ctx.Reply("Invalid max, use a number such as `2` or `0` for no limit.")
+					return
+				}
+				handleRolePanelEdit(ctx, args[1], func(panel *RolePanel) error {
+					panel.MaxRoles = maxRoles
+					return nil
+				})
+			default:
+				ctx.ReplyUsage()
+			}
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "create",
+				Description: "Post a role panel",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "style",
+						Description: "Buttons or a select menu",
+						Required:    true,
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "buttons", Value: PanelStyleButtons},
+							{Name: "select", Value: PanelStyleSelect},
+						},
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "roles",
+						Description: "Role names separated by ;, optionally prefixed by an emoji",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "title",
+						Description: "Message shown above the panel",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "max",
+						Description: "Most roles a member can pick from a select menu (0 for no limit)",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "edit",
+				Description: "Change a role panel",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message_id",
+						Description: "ID of the panel message",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "add",
+						Description: "Roles to add, separated by ;",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionRole,
+						Name:        "remove",
+						Description: "Role to take off the panel",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "title",
+						Description: "New message shown above the panel",
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        "max",
+						Description: "Most roles a member can pick from a select menu (0 for no limit)",
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show every role panel",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "delete",
+				Description: "Remove a role panel",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "message_id",
+						Description: "ID of the panel message",
+						Required:    true,
+					},
+				},
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				switch name {
+				case "create":
+					roles, err := parsePanelRoles(ctx.Session, ctx.GuildID, strings.Split(subOptions.String("roles"), ";"))
+					if err != nil {
+						ctx.Reply(err.Error())
+						return
+					}
+					if subOptions.Int("max") < 0 {
+						ctx.Reply("The role limit must not be negative.")
+						return
+					}
+					handleRolePanelCreate(ctx, subOptions.String("style"), subOptions.String("title"), subOptions.Int("max"), roles)
+				case "edit":
+					var added []*PanelRole
+					if subOptions.String("add") != "" {
+						var err error
+						added, err = parsePanelRoles(ctx.Session, ctx.GuildID, strings.Split(subOptions.String("add"), ";"))
+						if err != nil {
+							ctx.Reply(err.Error())
+							return
+						}
+					}
+					handleRolePanelEdit(ctx, subOptions.String("message_id"), func(panel *RolePanel) error {
+						if err := addPanelRoles(panel, added); err != nil {
+							return err
+						}
+						if opt, ok := subOptions["remove"]; ok {
+							if err := removePanelRole(panel, opt.RoleValue(nil, ctx.GuildID).ID); err != nil {
+								return err
+							}
+						}
+						if title := subOptions.String("title"); title != "" {
+							panel.Title = title
+						}
+						if _, ok := subOptions["max"]; ok {
+							if subOptions.Int("max") < 0 {
+								return fmt.Errorf("the role limit must not be negative")
+							}
+							panel.MaxRoles = subOptions.Int("max")
+						}
+						return nil
+					})
+				case "list":
+					handleRolePanelList(ctx)
+				case "delete":
+					handleRolePanelDelete(ctx, subOptions.String("message_id"))
+				}
+			}
+		},
+	})
+
+	registerCommand(&Command{
+		Name:        "permrole",
+		Usage:       "add|remove [admin|teacher|ta] [role name] | list",
+		Description: "Choose which roles may use teacher-only commands",
+		Permission:  PermissionAdmin,
+		Details:     "`admin` roles can manage these settings, `teacher` roles can run teacher commands and `ta` roles can run attendance lookups.",
+		Examples:    []string{"!permrole add teacher Teachers", "!permrole remove ta Assistants", "!permrole list"},
+		MinArgs:     1,
+		Handler: func(ctx *CommandContext, args []string) {
+			switch {
+			case args[0] == "list":
+				handlePermRoleList(ctx)
+			case (args[0] == "add" || args[0] == "remove") && len(args) >= 3:
+				role, err := findRoleByName(ctx.Session, ctx.GuildID, strings.Join(args[2:], " "))
+				if err != nil {
+					ctx.Reply("Role not found.")
+					return
+				}
+				if args[0] == "add" {
+					handlePermRoleAdd(ctx, args[1], role)
+				} else {
+					handlePermRoleRemove(ctx, args[1], role)
+				}
+			default:
+				ctx.ReplyUsage()
+			}
+		},
+		Options: []*discordgo.ApplicationCommandOption{
+			permRoleSubcommand("add", "Give a role an access level"),
+			permRoleSubcommand("remove", "Take an access level from a role"),
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show the roles for each access level",
+			},
+		},
+		SlashHandler: func(ctx *CommandContext, options SlashOptions) {
+			for name, sub := range options {
+				subOptions := optionMap(sub.Options)
+				switch name {
+				case "list":
+					handlePermRoleList(ctx)
+				case "add":
+					handlePermRoleAdd(ctx, subOptions["level"].StringValue(), subOptions["role"].RoleValue(ctx.Session, ctx.GuildID))
+				case "remove":
+					handlePermRoleRemove(ctx, subOptions["level"].StringValue(), subOptions["role"].RoleValue(ctx.Session, ctx.GuildID))
+				}
+			}
+		},
+	})
+}
+
+func permRoleSubcommand(name, description string) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionSubCommand,
+		Name:        name,
+		Description: description,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "level",
+				Description: "Access level",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "admin", Value: "admin"},
+					{Name: "teacher", Value: "teacher"},
+					{Name: "ta", Value: "ta"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "role",
+				Description: "Role",
+				Required:    true,
+			},
+		},
+	}
+}
+
+func policyClassOption() *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "class",
+		Description: "Class name (default the whole server)",
+	}
+}
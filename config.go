@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ==================================CONFIGURATION===========================================
+
+const (
+	defaultConfigFile      = "config.yaml"
+	defaultDBPath          = "./classroom.db"
+	defaultSheetsTokenFile = "token.json"
+	defaultCredentialsFile = "credentials.json"
+	defaultClassDuration   = 90 * time.Minute
+	defaultTimezone        = "UTC"
+	defaultExportDir       = "./exports"
+)
+
+// Config holds every setting the bot needs at startup. Values are read from a
+// YAML file and may be overridden by environment variables.
+type Config struct {
+	Token           string                 `yaml:"token"`
+	SpreadsheetID   string                 `yaml:"spreadsheet_id"`
+	SheetsAuth      string                 `yaml:"sheets_auth"`
+	SheetsCredsFile string                 `yaml:"sheets_credentials_file"`
+	SheetsTokenFile string                 `yaml:"sheets_token_file"`
+	ClassDuration   time.Duration          `yaml:"class_duration"`
+	DBPath          string                 `yaml:"db_path"`
+	Timezone        string                 `yaml:"timezone"`
+	PrefixCommands  *bool                  `yaml:"prefix_commands"`
+	Exporter        string                 `yaml:"exporter"`
+	ExportDir       string                 `yaml:"export_dir"`
+	AdminChannel    string                 `yaml:"admin_channel"`
+	Guilds          map[string]GuildConfig `yaml:"guilds"`
+}
+
+// GuildConfig overrides the global settings for a single guild. Empty fields
+// fall back to the top-level value.
+type GuildConfig struct {
+	SpreadsheetID string        `yaml:"spreadsheet_id"`
+	ClassDuration time.Duration `yaml:"class_duration"`
+	DBPath        string        `yaml:"db_path"`
+	Timezone      string        `yaml:"timezone"`
+	Exporter      string        `yaml:"exporter"`
+	AdminChannel  string        `yaml:"admin_channel"`
+}
+
+// ConfigError lists every problem found while validating a Config.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// loadConfig reads the YAML file at path (a missing file is allowed so the bot
+// can be configured from the environment alone), applies environment
+// overrides and defaults, and validates the result.
+func loadConfig(path string) (*Config, error) {
+	cfg, problems, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, cfg.validate()...)
+
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// readConfig reads the file and applies environment overrides and defaults
+// without validating, for the `auth` subcommand which only needs the Sheets
+// settings. It returns the problems found in the environment.
+func readConfig(path string) (*Config, []string, error) {
+	cfg := &Config{}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("unable to read config file %s: %v", path, err)
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(b, cfg); err != nil {
+			return nil, nil, fmt.Errorf("unable to parse config file %s: %v", path, err)
+		}
+	}
+
+	problems := cfg.applyEnv()
+	cfg.applyDefaults()
+	return cfg, problems, nil
+}
+
+// applyEnv overrides file values with DISCORD_TOKEN, SPREADSHEET_ID,
+// SHEETS_AUTH, SHEETS_CREDENTIALS_FILE, SHEETS_TOKEN_FILE, CLASS_DURATION,
+// DB_PATH, TIMEZONE, PREFIX_COMMANDS, EXPORTER, EXPORT_DIR and ADMIN_CHANNEL
+// when they are set.
+func (c *Config) applyEnv() []string {
+	var problems []string
+
+	if v, ok := os.LookupEnv("DISCORD_TOKEN"); ok {
+		c.Token = v
+	}
+	if v, ok := os.LookupEnv("SPREADSHEET_ID"); ok {
+		c.SpreadsheetID = v
+	}
+	if v, ok := os.LookupEnv("SHEETS_AUTH"); ok {
+		c.SheetsAuth = v
+	}
+	if v, ok := os.LookupEnv("SHEETS_CREDENTIALS_FILE"); ok {
+		c.SheetsCredsFile = v
+	}
+	if v, ok := os.LookupEnv("SHEETS_TOKEN_FILE"); ok {
+		c.SheetsTokenFile = v
+	}
+	if v, ok := os.LookupEnv("DB_PATH"); ok {
+		c.DBPath = v
+	}
+	if v, ok := os.LookupEnv("TIMEZONE"); ok {
+		c.Timezone = v
+	}
+	if v, ok := os.LookupEnv("EXPORTER"); ok {
+		c.Exporter = v
+	}
+	if v, ok := os.LookupEnv("EXPORT_DIR"); ok {
+		c.ExportDir = v
+	}
+	if v, ok := os.LookupEnv("ADMIN_CHANNEL"); ok {
+		c.AdminChannel = v
+	}
+	if v, ok := os.LookupEnv("CLASS_DURATION"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("CLASS_DURATION %q is not a valid duration (e.g. 90m, 1h30m)", v))
+		} else {
+			c.ClassDuration = d
+		}
+	}
+	if v, ok := os.LookupEnv("PREFIX_COMMANDS"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("PREFIX_COMMANDS %q is not a boolean", v))
+		} else {
+			c.PrefixCommands = &b
+		}
+	}
+	return problems
+}
+
+func (c *Config) applyDefaults() {
+	if c.SheetsAuth == "" {
+		c.SheetsAuth = SheetsAuthOAuth
+	}
+	if c.SheetsCredsFile == "" && c.SheetsAuth != SheetsAuthDefault {
+		c.SheetsCredsFile = defaultCredentialsFile
+	}
+	if c.SheetsTokenFile == "" {
+		c.SheetsTokenFile = defaultSheetsTokenFile
+	}
+	if c.DBPath == "" {
+		c.DBPath = defaultDBPath
+	}
+	if c.ClassDuration == 0 {
+		c.ClassDuration = defaultClassDuration
+	}
+	if c.Timezone == "" {
+		c.Timezone = defaultTimezone
+	}
+	if c.PrefixCommands == nil {
+		enabled := true
+		c.PrefixCommands = &enabled
+	}
+	if c.Exporter == "" {
+		c.Exporter = ExporterSheets
+	}
+	if c.ExportDir == "" {
+		c.ExportDir = defaultExportDir
+	}
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+
+	if c.Token == "" {
+		problems = append(problems, "token is missing (set `token` in the config file or DISCORD_TOKEN)")
+	}
+	if !validExporter(c.Exporter) {
+		problems = append(problems, fmt.Sprintf("exporter %q must be one of sheets, csv or xlsx", c.Exporter))
+	}
+	if c.Exporter == ExporterSheets && c.SpreadsheetID == "" {
+		problems = append(problems, "spreadsheet_id is missing (set `spreadsheet_id` in the config file or SPREADSHEET_ID, or use another exporter)")
+	}
+	switch c.SheetsAuth {
+	case SheetsAuthOAuth, SheetsAuthServiceAccount, SheetsAuthDefault:
+	default:
+		problems = append(problems, fmt.Sprintf("sheets_auth %q must be one of oauth, service_account or default", c.SheetsAuth))
+	}
+	if c.AdminChannel != "" && !isSnowflake(c.AdminChannel) {
+		problems = append(problems, fmt.Sprintf("admin_channel %q is not a Discord channel ID", c.AdminChannel))
+	}
+	if c.ClassDuration < 0 {
+		problems = append(problems, fmt.Sprintf("class_duration %s must be positive", c.ClassDuration))
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		problems = append(problems, fmt.Sprintf("timezone %q is not an IANA timezone (e.g. Asia/Bangkok)", c.Timezone))
+	}
+
+	for guildID, g := range c.Guilds {
+		if !isSnowflake(guildID) {
+			problems = append(problems, fmt.Sprintf("guilds: %q is not a Discord guild ID", guildID))
+		}
+		if g.ClassDuration < 0 {
+			problems = append(problems, fmt.Sprintf("guilds.%s.class_duration %s must be positive", guildID, g.ClassDuration))
+		}
+		if g.Timezone != "" {
+			if _, err := time.LoadLocation(g.Timezone); err != nil {
+				problems = append(problems, fmt.Sprintf("guilds.%s.timezone %q is not an IANA timezone", guildID, g.Timezone))
+			}
+		}
+		if g.AdminChannel != "" && !isSnowflake(g.AdminChannel) {
+			problems = append(problems, fmt.Sprintf("guilds.%s.admin_channel %q is not a Discord channel ID", guildID, g.AdminChannel))
+		}
+		if g.Exporter != "" && !validExporter(g.Exporter) {
+			problems = append(problems, fmt.Sprintf("guilds.%s.exporter %q must be one of sheets, csv or xlsx", guildID, g.Exporter))
+		}
+		if c.ExporterFor(guildID) == ExporterSheets && c.SpreadsheetFor(guildID) == "" {
+			problems = append(problems, fmt.Sprintf("guilds.%s.spreadsheet_id is missing for the sheets exporter", guildID))
+		}
+	}
+	return problems
+}
+
+// PrefixCommandsEnabled reports whether the legacy `!command` messages are
+// still handled alongside slash commands.
+func (c *Config) PrefixCommandsEnabled() bool {
+	return c.PrefixCommands != nil && *c.PrefixCommands
+}
+
+// SpreadsheetFor returns the spreadsheet used by guildID.
+func (c *Config) SpreadsheetFor(guildID string) string {
+	if g, ok := c.Guilds[guildID]; ok && g.SpreadsheetID != "" {
+		return g.SpreadsheetID
+	}
+	return c.SpreadsheetID
+}
+
+// ClassDurationFor returns the default class length for guildID.
+func (c *Config) ClassDurationFor(guildID string) time.Duration {
+	if g, ok := c.Guilds[guildID]; ok && g.ClassDuration > 0 {
+		return g.ClassDuration
+	}
+	return c.ClassDuration
+}
+
+// TimezoneFor returns the configured IANA timezone for guildID.
+func (c *Config) TimezoneFor(guildID string) string {
+	if g, ok := c.Guilds[guildID]; ok && g.Timezone != "" {
+		return g.Timezone
+	}
+	return c.Timezone
+}
+
+// ExporterFor returns the attendance exporter used by guildID.
+func (c *Config) ExporterFor(guildID string) string {
+	if g, ok := c.Guilds[guildID]; ok && g.Exporter != "" {
+		return g.Exporter
+	}
+	return c.Exporter
+}
+
+// AdminChannelFor returns the channel that hears about failures in guildID,
+// or "" when none is configured.
+func (c *Config) AdminChannelFor(guildID string) string {
+	if g, ok := c.Guilds[guildID]; ok && g.AdminChannel != "" {
+		return g.AdminChannel
+	}
+	return c.AdminChannel
+}
+
+func validExporter(name string) bool {
+	return name == ExporterSheets || name == ExporterCSV || name == ExporterXLSX
+}
+
+// DBPathFor returns the SQLite database file used by guildID.
+func (c *Config) DBPathFor(guildID string) string {
+	if g, ok := c.Guilds[guildID]; ok && g.DBPath != "" {
+		return g.DBPath
+	}
+	return c.DBPath
+}
+
+// DBPaths returns every distinct database file referenced by the config,
+// starting with the default one.
+func (c *Config) DBPaths() []string {
+	paths := []string{c.DBPath}
+	seen := map[string]bool{c.DBPath: true}
+	for _, g := range c.Guilds {
+		if g.DBPath != "" && !seen[g.DBPath] {
+			seen[g.DBPath] = true
+			paths = append(paths, g.DBPath)
+		}
+	}
+	return paths
+}
+
+func isSnowflake(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
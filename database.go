@@ -0,0 +1,314 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dbExecutor is satisfied by both *sql.DB and *sql.Tx, so helpers can run
+// on their own or as part of a caller's transaction.
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// databases holds one open handle per configured database file.
+var databases = make(map[string]*sql.DB)
+
+// openDatabases opens every database file referenced by the config and makes
+// sure its tables exist. The default database is also assigned to db.
+func openDatabases(cfg *Config) error {
+	for _, path := range cfg.DBPaths() {
+		conn, err := sql.Open("sqlite3", path)
+		if err != nil {
+			return fmt.Errorf("error opening database %s: %v", path, err)
+		}
+		databases[path] = conn
+
+		if err := initSchema(conn); err != nil {
+			return fmt.Errorf("error preparing database %s: %v", path, err)
+		}
+	}
+	db = databases[cfg.DBPath]
+	return nil
+}
+
+func closeDatabases() {
+	for _, conn := range databases {
+		conn.Close()
+	}
+}
+
+// guildDB returns the database handle configured for guildID.
+func guildDB(guildID string) *sql.DB {
+	if conn, ok := databases[cfg.DBPathFor(guildID)]; ok {
+		return conn
+	}
+	return db
+}
+
+func initSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS attendance (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			guild_id TEXT,
+			user_id TEXT,
+			join_time DATETIME,
+			leave_time DATETIME,
+			voice_channel TEXT
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating attendance table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS voice_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			guild_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			attendance_id INTEGER,
+			event TEXT NOT NULL,
+			event_time DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating voice_events table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bot_heartbeats (
+			guild_id TEXT PRIMARY KEY,
+			seen_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating bot_heartbeats table: %v", err)
+	}
+
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS students (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            guild_id TEXT NOT NULL,
+            user_id TEXT NOT NULL,
+			username VARCHAR(32),
+            real_name TEXT NOT NULL DEFAULT '',
+            student_number TEXT NOT NULL DEFAULT '',
+            section TEXT NOT NULL DEFAULT '',
+            active INTEGER NOT NULL DEFAULT 1,
+            UNIQUE(guild_id, user_id) ON CONFLICT REPLACE
+        )
+    `)
+	if err != nil {
+		return fmt.Errorf("error creating students table: %v", err)
+	}
+	err = addMissingColumns(db, "students", []string{
+		"real_name TEXT NOT NULL DEFAULT ''",
+		"student_number TEXT NOT NULL DEFAULT ''",
+		"section TEXT NOT NULL DEFAULT ''",
+		"active INTEGER NOT NULL DEFAULT 1",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS roster_roles (
+			guild_id TEXT NOT NULL,
+			role_id TEXT NOT NULL,
+			PRIMARY KEY (guild_id, role_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating roster_roles table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS enrollments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			guild_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			role_id TEXT NOT NULL,
+			started_at DATETIME NOT NULL,
+			ended_at DATETIME
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating enrollments table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS permission_roles (
+			guild_id TEXT NOT NULL,
+			level TEXT NOT NULL,
+			role_id TEXT NOT NULL,
+			PRIMARY KEY (guild_id, level, role_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating permission_roles table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS class_schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			guild_id TEXT NOT NULL,
+			class_name TEXT NOT NULL COLLATE NOCASE,
+			weekdays TEXT NOT NULL DEFAULT '',
+			start_time TEXT NOT NULL,
+			duration_minutes INTEGER NOT NULL,
+			timezone TEXT NOT NULL,
+			voice_channel TEXT NOT NULL DEFAULT '',
+			UNIQUE(guild_id, class_name)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating class_schedules table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS class_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			guild_id TEXT NOT NULL,
+			class_name TEXT NOT NULL,
+			sheet_name TEXT NOT NULL,
+			session_date TEXT NOT NULL,
+			scheduled_start DATETIME NOT NULL,
+			scheduled_end DATETIME NOT NULL,
+			actual_start DATETIME NOT NULL,
+			actual_end DATETIME,
+			stopped_by TEXT NOT NULL DEFAULT '',
+			channel_id TEXT NOT NULL DEFAULT '',
+			voice_channel TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating class_sessions table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS guild_settings (
+			guild_id TEXT PRIMARY KEY,
+			timezone TEXT NOT NULL DEFAULT '',
+			teacher_channel_id TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating guild_settings table: %v", err)
+	}
+	err = addMissingColumns(db, "guild_settings", []string{"teacher_channel_id TEXT NOT NULL DEFAULT ''"})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS attendance_policies (
+			guild_id TEXT NOT NULL,
+			class_name TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+			pre_window_minutes INTEGER NOT NULL,
+			late_minutes INTEGER NOT NULL,
+			min_presence_percent INTEGER NOT NULL,
+			early_leave_minutes INTEGER NOT NULL,
+			present_label TEXT NOT NULL,
+			late_label TEXT NOT NULL,
+			absent_label TEXT NOT NULL,
+			early_leave_label TEXT NOT NULL,
+			PRIMARY KEY (guild_id, class_name)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating attendance_policies table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reaction_roles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			guild_id TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			role_id TEXT NOT NULL,
+			UNIQUE(message_id, emoji)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating reaction_roles table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reaction_role_menus (
+			message_id TEXT PRIMARY KEY,
+			guild_id TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			mode TEXT NOT NULL DEFAULT 'normal',
+			max_roles INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating reaction_role_menus table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS role_panels (
+			message_id TEXT PRIMARY KEY,
+			guild_id TEXT NOT NULL,
+			channel_id TEXT NOT NULL,
+			style TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			max_roles INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating role_panels table: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS role_panel_roles (
+			message_id TEXT NOT NULL,
+			role_id TEXT NOT NULL,
+			label TEXT NOT NULL,
+			emoji TEXT NOT NULL DEFAULT '',
+			position INTEGER NOT NULL,
+			PRIMARY KEY (message_id, role_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating role_panel_roles table: %v", err)
+	}
+
+	return nil
+}
+
+// addMissingColumns adds the column definitions (name first) that table does
+// not have yet, for databases created by older versions of the bot.
+func addMissingColumns(db *sql.DB, table string, columns []string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("error reading columns of %s: %v", table, err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading columns of %s: %v", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %v", table, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Formats accepted by `!export`.
+const (
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+	exportJSON = "json"
+)
+
+// ==================================ATTENDANCE EXPORT===========================================
+// AttendanceMatrix is the attendance of every student in every session of a
+// class over a date range, built locally from the database.
+type AttendanceMatrix struct {
+	ClassName string
+	From, To  string
+	Sessions  []ClassSession
+	Students  []Student
+	Enrolled  [][]bool             // By student, then session
+	Results   [][]AttendanceResult // By student, then session; zero when not enrolled
+	Marks     [][]string           // Results formatted with the class policy; blank when not enrolled
+}
+
+// buildAttendanceMatrix evaluates the students enrolled in any of the
+// sessions of className held between from and to, each only in the sessions
+// they were enrolled for.
+func buildAttendanceMatrix(guildID, className, from, to string) (*AttendanceMatrix, error) {
+	db := guildDB(guildID)
+	sessions, err := fetchClassSessions(db, guildID, className, from, to)
+	if err != nil {
+		return nil, err
+	}
+	enrollments, err := fetchEnrollments(db, guildID)
+	if err != nil {
+		return nil, err
+	}
+	students := enrollments.StudentsIn(sessions)
+
+	matrix := &AttendanceMatrix{ClassName: className, From: from, To: to, Sessions: sessions, Students: students}
+	if len(sessions) > 0 {
+		matrix.ClassName = sessions[0].ClassName
+	}
+	results := newSessionResults(db)
+	for _, student := range students {
+		enrolled := make([]bool, len(sessions))
+		studentResults := make([]AttendanceResult, len(sessions))
+		marks := make([]string, len(sessions))
+		for i, session := range sessions {
+			enrolled[i] = enrollments.EnrolledAt(student.UserID, session.ScheduledStart)
+			if !enrolled[i] {
+				continue
+			}
+			result, err := results.evaluate(student.UserID, session)
+			if err != nil {
+				return nil, err
+			}
+			studentResults[i] = result
+			marks[i] = result.Mark(results.policies[session.ClassName])
+		}
+		matrix.Enrolled = append(matrix.Enrolled, enrolled)
+		matrix.Results = append(matrix.Results, studentResults)
+		matrix.Marks = append(matrix.Marks, marks)
+	}
+	return matrix, nil
+}
+
+// sessionTitles returns a column title per session: its date, with the start
+// time added when a class met more than once that day.
+func (m *AttendanceMatrix) sessionTitles(loc *time.Location) []string {
+	perDate := make(map[string]int)
+	for _, session := range m.Sessions {
+		perDate[session.Date]++
+	}
+	titles := make([]string, len(m.Sessions))
+	for i, session := range m.Sessions {
+		titles[i] = session.Date
+		if perDate[session.Date] > 1 {
+			titles[i] += " " + session.ScheduledStart.In(loc).Format("15:04")
+		}
+	}
+	return titles
+}
+
+// Rows lays the matrix out as a table: one row per student with a column per
+// session, followed by the student's totals. Sessions a student was not
+// enrolled for are left blank and out of the totals.
+func (m *AttendanceMatrix) Rows(loc *time.Location) [][]string {
+	header := []string{"Student Number", "Name", "Username", "Discord ID", "Section"}
+	header = append(header, m.sessionTitles(loc)...)
+	header = append(header, "Present", "Late", "Left Early", "Absent", "Attendance %")
+
+	rows := [][]string{header}
+	for i, student := range m.Students {
+		var totals ReportTotals
+		for j, result := range m.Results[i] {
+			if m.Enrolled[i][j] {
+				totals.Add(result)
+			}
+		}
+		row := []string{student.StudentNumber, student.Name(), student.Username, student.UserID, student.Section}
+		row = append(row, m.Marks[i]...)
+		row = append(row, fmt.Sprint(totals.Present), fmt.Sprint(totals.Late), fmt.Sprint(totals.EarlyLeave),
+			fmt.Sprint(totals.Absent), fmt.Sprint(totals.AttendanceRate()))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+type jsonExportSession struct {
+	Date  string    `json:"date"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type jsonExportMark struct {
+	Status      string `json:"status"`
+	Percent     int    `json:"percent"`
+	LateSeconds int    `json:"late_seconds,omitempty"`
+	Mark        string `json:"mark"`
+}
+
+type jsonExportStudent struct {
+	UserID         string            `json:"user_id"`
+	Username       string            `json:"username"`
+	RealName       string            `json:"real_name,omitempty"`
+	StudentNumber  string            `json:"student_number,omitempty"`
+	Section        string            `json:"section,omitempty"`
+	Marks          []*jsonExportMark `json:"marks"` // One per session, in order; null when not enrolled
+	AttendanceRate int               `json:"attendance_percent"`
+}
+
+type jsonExport struct {
+	Class    string              `json:"class"`
+	From     string              `json:"from"`
+	To       string              `json:"to"`
+	Sessions []jsonExportSession `json:"sessions"`
+	Students []jsonExportStudent `json:"students"`
+}
+
+// JSON encodes the matrix with the marks of each student in session order.
+func (m *AttendanceMatrix) JSON() ([]byte, error) {
+	export := jsonExport{Class: m.ClassName, From: m.From, To: m.To}
+	for _, session := range m.Sessions {
+		export.Sessions = append(export.Sessions, jsonExportSession{Date: session.Date, Start: session.ScheduledStart, End: session.End()})
+	}
+	for i, student := range m.Students {
+		var totals ReportTotals
+		entry := jsonExportStudent{
+			UserID:        student.UserID,
+			Username:      student.Username,
+			RealName:      student.RealName,
+			StudentNumber: student.StudentNumber,
+			Section:       student.Section,
+		}
+		for j, result := range m.Results[i] {
+			if !m.Enrolled[i][j] {
+				entry.Marks = append(entry.Marks, nil)
+				continue
+			}
+			totals.Add(result)
+			entry.Marks = append(entry.Marks, &jsonExportMark{
+				Status:      result.Status,
+				Percent:     result.Percent,
+				LateSeconds: int(result.Late.Seconds()),
+				Mark:        m.Marks[i][j],
+			})
+		}
+		entry.AttendanceRate = totals.AttendanceRate()
+		export.Students = append(export.Students, entry)
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// handleExport sends the attendance of className between from and to as a
+// file in format.
+func handleExport(ctx *CommandContext, className, from, to, format string) {
+	if err := checkReportRange(from, to); err != nil {
+		ctx.Reply(err.Error() + ".")
+		return
+	}
+
+	ctx.Defer()
+	matrix, err := buildAttendanceMatrix(ctx.GuildID, className, from, to)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to read attendance data.")
+		return
+	}
+	if len(matrix.Sessions) == 0 {
+		ctx.Reply(fmt.Sprintf("No sessions of %s were recorded from %s to %s.", className, from, to))
+		return
+	}
+	if len(matrix.Students) == 0 {
+		ctx.Reply("No students are registered yet.")
+		return
+	}
+
+	name := safeFileName(fmt.Sprintf("%s %s to %s", matrix.ClassName, from, to))
+	file := &Attachment{}
+	switch format {
+	case exportXLSX:
+		file.Name, file.ContentType = name+xlsxFormat.ext, xlsxFormat.contentType
+		file.Data, err = encodeXLSX(matrix.ClassName, matrix.Rows(guildLocation(ctx.GuildID)))
+	case exportJSON:
+		file.Name, file.ContentType = name+".json", "application/json"
+		file.Data, err = matrix.JSON()
+	default:
+		file.Name, file.ContentType = name+csvFormat.ext, csvFormat.contentType
+		file.Data, err = encodeCSV(matrix.Rows(guildLocation(ctx.GuildID)))
+	}
+	if err != nil {
+		log.Printf("Error encoding attendance export: %v", err)
+		ctx.Reply("Failed to build the export file.")
+		return
+	}
+
+	ctx.ReplyFile(fmt.Sprintf("Attendance of %s: %d students, %d sessions from %s to %s.",
+		matrix.ClassName, len(matrix.Students), len(matrix.Sessions), from, to), file)
+}
+
+// parseExportArgs reads `[class name] [from] [to] [format]` prefix arguments.
+func parseExportArgs(args []string) (className, from, to, format string, err error) {
+	format = exportCSV
+	if last := strings.ToLower(args[len(args)-1]); last == exportCSV || last == exportXLSX || last == exportJSON {
+		format = last
+		args = args[:len(args)-1]
+	}
+	words, from, to, err := splitReportDates(args)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	if len(words) == 0 || to == "" {
+		return "", "", "", "", fmt.Errorf("give a class name, a start date and an end date")
+	}
+	return strings.Join(words, " "), from, to, format, nil
+}
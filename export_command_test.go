@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAttendanceMatrixEnrollments(t *testing.T) {
+	conn := useTestDatabase(t)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 9, 0, 0, 0, time.UTC) }
+	for _, d := range []int{4, 11} {
+		session := &ClassSession{
+			GuildID:        "g1",
+			ClassName:      "Math",
+			SheetName:      "Math",
+			Date:           day(d).Format(reportDateLayout),
+			ScheduledStart: day(d),
+			ScheduledEnd:   day(d).Add(time.Hour),
+			ActualStart:    day(d),
+			StoppedBy:      stoppedBySchedule,
+		}
+		if err := insertClassSession(conn, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, student := range []Student{{UserID: "left", Username: "left"}, {UserID: "joined", Username: "joined"}, {UserID: "later", Username: "later"}} {
+		if err := saveStudent(conn, "g1", student); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertEnrollment(t, conn, "left", "r1", day(1), day(8))
+	insertEnrollment(t, conn, "joined", "r1", day(8), time.Time{})
+	insertEnrollment(t, conn, "later", "r1", day(20), time.Time{})
+	insertAttendance(t, conn, "left", "", day(4), day(4).Add(time.Hour))
+
+	matrix, err := buildAttendanceMatrix("g1", "Math", "2024-03-01", "2024-03-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := studentIDs(matrix.Students), []string{"left", "joined"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("students = %v, want %v", got, want)
+	}
+	if want := [][]bool{{true, false}, {false, true}}; !reflect.DeepEqual(matrix.Enrolled, want) {
+		t.Errorf("enrolled = %v, want %v", matrix.Enrolled, want)
+	}
+
+	rows := matrix.Rows(time.UTC)
+	// Columns: five student details, two sessions, then Present, Late, Left Early, Absent, Attendance %
+	if got := rows[1][5:]; got[1] != "" || got[2] != "1" || got[5] != "0" || got[6] != "100" {
+		t.Errorf("row of the student who left = %q", got)
+	}
+	if got := rows[2][5:]; got[0] != "" || got[1] == "" || got[5] != "1" || got[6] != "0" {
+		t.Errorf("row of the student who joined = %q", got)
+	}
+}
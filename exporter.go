@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Attendance exporter backends, chosen per guild with `exporter` in the config.
+const (
+	ExporterSheets = "sheets"
+	ExporterCSV    = "csv"
+	ExporterXLSX   = "xlsx"
+)
+
+// ==================================ATTENDANCE EXPORTERS===========================================
+// AttendanceExporter writes attendance sheets: a roster of students with one
+// column of marks per class date.
+type AttendanceExporter interface {
+	// SheetExists reports whether sheetName has been created.
+	SheetExists(sheetName string) (bool, error)
+	// CreateRosterSheet creates sheetName with a User ID/Number/Username
+	// header and one row per student, hiding the user ID column where the
+	// format allows. It returns a link or path to show the teacher.
+	CreateRosterSheet(sheetName string, students []Student) (string, error)
+	// SyncRoster matches the sheet's rows to students by user ID, appending
+	// rows for new students and flagging departed ones. It returns the
+	// zero-based data row (below the header) of every student.
+	SyncRoster(sheetName string, students []Student) (map[string]int, error)
+	// UpsertDateColumn returns the zero-based index of the column titled
+	// title, appending it to the header when it is missing.
+	UpsertDateColumn(sheetName, title string) (int, error)
+	// WriteMarks writes marks, keyed by zero-based data row, into column.
+	// Rows without a mark are left as they are.
+	WriteMarks(sheetName string, column int, marks map[int]string) error
+	// File returns sheetName as a file to attach to a reply, or nil when the
+	// backend is not file based.
+	File(sheetName string) (*Attachment, error)
+}
+
+// newExporter returns the exporter configured for guildID.
+func newExporter(guildID string) (AttendanceExporter, error) {
+	switch kind := cfg.ExporterFor(guildID); kind {
+	case ExporterSheets:
+		scheduler, err := sheetsClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Google Sheets service: %v", err)
+		}
+		return newSheetsExporter(scheduler, cfg.SpreadsheetFor(guildID)), nil
+	case ExporterCSV:
+		return &FileExporter{dir: guildExportDir(guildID), format: csvFormat}, nil
+	case ExporterXLSX:
+		return &FileExporter{dir: guildExportDir(guildID), format: xlsxFormat}, nil
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", kind)
+	}
+}
+
+func guildExportDir(guildID string) string {
+	return filepath.Join(cfg.ExportDir, guildID)
+}
+
+// ===================================Roster rows===========================================
+// Every roster sheet starts with the columns User ID, Number and Username.
+// Marks are placed by user ID, so adding students or sorting the sheet by
+// hand never moves a mark onto someone else. Sheets made before the ID column
+// existed start with Number and Username; they get an empty ID column that is
+// filled in by matching usernames.
+const (
+	rosterIDHeader = "User ID"
+	rosterColumns  = 3 // User ID, Number, Username
+	departedSuffix = " (left)"
+)
+
+func rosterHeader() []string {
+	return []string{rosterIDHeader, "Number", "Username"}
+}
+
+func rosterRow(number int, student Student) []string {
+	return []string{student.UserID, strconv.Itoa(number), student.Username}
+}
+
+// isLegacyRoster reports whether header belongs to a sheet without the user ID column.
+func isLegacyRoster(header []string) bool {
+	return len(header) == 0 || header[0] != rosterIDHeader
+}
+
+// syncSheetRows matches roster rows (User ID, Number, Username; header excluded)
+// to students. Known students get their current username, rows whose student
+// is gone are flagged, legacy rows without an ID are claimed by username, and
+// students without a row are appended. It returns the updated rows and the
+// row of each student.
+func syncSheetRows(rows [][]string, students []Student) ([][]string, map[string]int) {
+	byID := make(map[string]Student, len(students))
+	for _, student := range students {
+		byID[student.UserID] = student
+	}
+
+	rowOf := make(map[string]int, len(students))
+	for i := range rows {
+		for len(rows[i]) < rosterColumns {
+			rows[i] = append(rows[i], "")
+		}
+		id := rows[i][0]
+		if id == "" {
+			continue
+		}
+		name := strings.TrimSuffix(rows[i][2], departedSuffix)
+		if student, ok := byID[id]; ok {
+			if _, dup := rowOf[id]; !dup {
+				rowOf[id] = i
+				name = student.Username
+			} else {
+				name += departedSuffix
+			}
+		} else {
+			name += departedSuffix
+		}
+		rows[i][2] = name
+	}
+
+	// Legacy rows: give each the ID of the first unplaced student with that
+	// name, or flag it when nobody matches.
+	for i, row := range rows {
+		if row[0] != "" || row[2] == "" {
+			continue
+		}
+		name := strings.TrimSuffix(row[2], departedSuffix)
+		rows[i][2] = name + departedSuffix
+		for _, student := range students {
+			if _, placed := rowOf[student.UserID]; !placed && student.Username == name {
+				rows[i][0] = student.UserID
+				rows[i][2] = student.Username
+				rowOf[student.UserID] = i
+				break
+			}
+		}
+	}
+
+	for _, student := range students {
+		if _, placed := rowOf[student.UserID]; placed {
+			continue
+		}
+		rowOf[student.UserID] = len(rows)
+		rows = append(rows, rosterRow(len(rows)+1, student))
+	}
+	return rows, rowOf
+}
+
+// ===================================File exporter===========================================
+// tableFormat reads and writes a whole sheet as rows of cells.
+type tableFormat struct {
+	ext         string
+	contentType string
+	read        func(path string) ([][]string, error)
+	write       func(path string, sheetName string, rows [][]string) error
+}
+
+// FileExporter keeps every sheet in its own local file under dir.
+type FileExporter struct {
+	dir    string
+	format tableFormat
+}
+
+func (e *FileExporter) path(sheetName string) string {
+	return filepath.Join(e.dir, safeFileName(sheetName)+e.format.ext)
+}
+
+// readRows reads sheetName, adding the user ID column to legacy sheets.
+func (e *FileExporter) readRows(sheetName string) ([][]string, error) {
+	rows, err := e.format.read(e.path(sheetName))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return [][]string{rosterHeader()}, nil
+	}
+	if isLegacyRoster(rows[0]) {
+		for i, row := range rows {
+			id := ""
+			if i == 0 {
+				id = rosterIDHeader
+			}
+			rows[i] = append([]string{id}, row...)
+		}
+	}
+	return rows, nil
+}
+
+func (e *FileExporter) SheetExists(sheetName string) (bool, error) {
+	_, err := os.Stat(e.path(sheetName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (e *FileExporter) CreateRosterSheet(sheetName string, students []Student) (string, error) {
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return "", fmt.Errorf("unable to create export directory: %v", err)
+	}
+	rows := [][]string{rosterHeader()}
+	for i, student := range students {
+		rows = append(rows, rosterRow(i+1, student))
+	}
+	if err := e.format.write(e.path(sheetName), sheetName, rows); err != nil {
+		return "", err
+	}
+	return e.path(sheetName), nil
+}
+
+func (e *FileExporter) SyncRoster(sheetName string, students []Student) (map[string]int, error) {
+	rows, err := e.readRows(sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	roster := make([][]string, len(rows)-1)
+	for i, row := range rows[1:] {
+		roster[i] = append([]string(nil), row[:min(len(row), rosterColumns)]...)
+	}
+	roster, rowOf := syncSheetRows(roster, students)
+
+	for i, cells := range roster {
+		rowIndex := i + 1 // Row 0 is the header
+		if rowIndex == len(rows) {
+			rows = append(rows, nil)
+		}
+		for len(rows[rowIndex]) < rosterColumns {
+			rows[rowIndex] = append(rows[rowIndex], "")
+		}
+		copy(rows[rowIndex], cells)
+	}
+	return rowOf, e.format.write(e.path(sheetName), sheetName, rows)
+}
+
+func (e *FileExporter) UpsertDateColumn(sheetName, title string) (int, error) {
+	rows, err := e.readRows(sheetName)
+	if err != nil {
+		return 0, err
+	}
+	for index, value := range rows[0] {
+		if value == title {
+			return index, nil
+		}
+	}
+	rows[0] = append(rows[0], title)
+	return len(rows[0]) - 1, e.format.write(e.path(sheetName), sheetName, rows)
+}
+
+func (e *FileExporter) WriteMarks(sheetName string, column int, marks map[int]string) error {
+	rows, err := e.readRows(sheetName)
+	if err != nil {
+		return err
+	}
+	for row, mark := range marks {
+		rowIndex := row + 1 // Row 0 is the header
+		for len(rows) <= rowIndex {
+			rows = append(rows, nil)
+		}
+		for len(rows[rowIndex]) <= column {
+			rows[rowIndex] = append(rows[rowIndex], "")
+		}
+		rows[rowIndex][column] = mark
+	}
+	return e.format.write(e.path(sheetName), sheetName, rows)
+}
+
+func (e *FileExporter) File(sheetName string) (*Attachment, error) {
+	data, err := os.ReadFile(e.path(sheetName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read exported file: %v", err)
+	}
+	return &Attachment{
+		Name:        safeFileName(sheetName) + e.format.ext,
+		ContentType: e.format.contentType,
+		Data:        data,
+	}, nil
+}
+
+// safeFileName replaces characters that are not allowed in file names.
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "attendance"
+	}
+	return name
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSyncSheetRows(t *testing.T) {
+	alice := Student{UserID: "u1", Username: "alice"}
+	bob := Student{UserID: "u2", Username: "bob"}
+
+	tests := []struct {
+		name      string
+		rows      [][]string
+		students  []Student
+		wantRows  [][]string
+		wantRowOf map[string]int
+	}{
+		{
+			name:      "legacy sheet",
+			rows:      [][]string{{"", "1", "bob"}, {"", "2", "alice"}},
+			students:  []Student{alice, bob},
+			wantRows:  [][]string{{"u2", "1", "bob"}, {"u1", "2", "alice"}},
+			wantRowOf: map[string]int{"u1": 1, "u2": 0},
+		},
+		{
+			name:      "legacy row without a student",
+			rows:      [][]string{{"", "1", "carol"}},
+			students:  []Student{alice},
+			wantRows:  [][]string{{"", "1", "carol (left)"}, {"u1", "2", "alice"}},
+			wantRowOf: map[string]int{"u1": 1},
+		},
+		{
+			name:      "renamed user",
+			rows:      [][]string{{"u1", "1", "alice_old"}},
+			students:  []Student{alice},
+			wantRows:  [][]string{{"u1", "1", "alice"}},
+			wantRowOf: map[string]int{"u1": 0},
+		},
+		{
+			name:      "removed student",
+			rows:      [][]string{{"u1", "1", "alice"}, {"u2", "2", "bob"}},
+			students:  []Student{alice},
+			wantRows:  [][]string{{"u1", "1", "alice"}, {"u2", "2", "bob (left)"}},
+			wantRowOf: map[string]int{"u1": 0},
+		},
+		{
+			name:      "removed student comes back",
+			rows:      [][]string{{"u2", "1", "bob (left)"}},
+			students:  []Student{bob},
+			wantRows:  [][]string{{"u2", "1", "bob"}},
+			wantRowOf: map[string]int{"u2": 0},
+		},
+		{
+			name:      "duplicate username",
+			rows:      [][]string{{"", "1", "sam"}, {"", "2", "sam"}, {"", "3", "sam"}},
+			students:  []Student{{UserID: "s1", Username: "sam"}, {UserID: "s2", Username: "sam"}},
+			wantRows:  [][]string{{"s1", "1", "sam"}, {"s2", "2", "sam"}, {"", "3", "sam (left)"}},
+			wantRowOf: map[string]int{"s1": 0, "s2": 1},
+		},
+		{
+			name:      "duplicate user ID",
+			rows:      [][]string{{"u1", "1", "alice"}, {"u1", "2", "alice"}},
+			students:  []Student{alice},
+			wantRows:  [][]string{{"u1", "1", "alice"}, {"u1", "2", "alice (left)"}},
+			wantRowOf: map[string]int{"u1": 0},
+		},
+		{
+			name:      "new student",
+			rows:      [][]string{{"u1", "1"}},
+			students:  []Student{alice, bob},
+			wantRows:  [][]string{{"u1", "1", "alice"}, {"u2", "2", "bob"}},
+			wantRowOf: map[string]int{"u1": 0, "u2": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, rowOf := syncSheetRows(tt.rows, tt.students)
+			if !reflect.DeepEqual(rows, tt.wantRows) {
+				t.Errorf("rows = %q, want %q", rows, tt.wantRows)
+			}
+			if !reflect.DeepEqual(rowOf, tt.wantRowOf) {
+				t.Errorf("rowOf = %v, want %v", rowOf, tt.wantRowOf)
+			}
+		})
+	}
+}
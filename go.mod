@@ -7,6 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/oauth2 v0.18.0
 	google.golang.org/api v0.169.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
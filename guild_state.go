@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ==================================GUILD STATE===========================================
+// GuildState is the in-memory state of one guild. Discord event handlers and
+// the attendance sheet goroutines run concurrently, so every field is only
+// reached through methods that hold mu. Voice sessions have their own lock
+// because their updates include database and REST calls. Running attendance
+// jobs are owned by the SessionManager.
+type GuildState struct {
+	mu        sync.Mutex
+	schedules map[string]*ClassSchedule
+
+	voiceMu       sync.Mutex
+	voiceSessions map[string]*VoiceSession // Keyed by user ID
+}
+
+// StateStore hands out the GuildState of each guild.
+type StateStore struct {
+	mu     sync.Mutex
+	guilds map[string]*GuildState
+}
+
+var guildStates = &StateStore{guilds: make(map[string]*GuildState)}
+
+// Guild returns the state of guildID, creating it on first use.
+func (s *StateStore) Guild(guildID string) *GuildState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.guilds[guildID]
+	if !ok {
+		g = &GuildState{
+			voiceSessions: make(map[string]*VoiceSession),
+			schedules:     make(map[string]*ClassSchedule),
+		}
+		s.guilds[guildID] = g
+	}
+	return g
+}
+
+// WithVoiceSessions runs fn with exclusive access to the guild's voice
+// sessions, so a whole join/leave/move is applied without interleaving.
+func (g *GuildState) WithVoiceSessions(fn func(sessions map[string]*VoiceSession)) {
+	g.voiceMu.Lock()
+	defer g.voiceMu.Unlock()
+	fn(g.voiceSessions)
+}
+
+// CacheSchedule stores schedule under its lower-cased name.
+func (g *GuildState) CacheSchedule(schedule *ClassSchedule) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.schedules[strings.ToLower(schedule.Name)] = schedule
+}
+
+func (g *GuildState) UncacheSchedule(name string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.schedules, strings.ToLower(name))
+}
+
+func (g *GuildState) Schedule(name string) (*ClassSchedule, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	schedule, ok := g.schedules[strings.ToLower(name)]
+	return schedule, ok
+}
+
+// Schedules returns the guild's schedules ordered by start time.
+func (g *GuildState) Schedules() []*ClassSchedule {
+	g.mu.Lock()
+	schedules := make([]*ClassSchedule, 0, len(g.schedules))
+	for _, schedule := range g.schedules {
+		schedules = append(schedules, schedule)
+	}
+	g.mu.Unlock()
+
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].StartTime != schedules[j].StartTime {
+			return schedules[i].StartTime < schedules[j].StartTime
+		}
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// useTestDatabase points cfg and the database handles at a fresh SQLite file.
+func useTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	oldCfg, oldDB := cfg, db
+	cfg = &Config{DBPath: filepath.Join(t.TempDir(), "test.db"), Timezone: "UTC"}
+	if err := openDatabases(cfg); err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // Writers queue up instead of failing with SQLITE_BUSY
+	t.Cleanup(func() {
+		closeDatabases()
+		delete(databases, cfg.DBPath)
+		cfg, db = oldCfg, oldDB
+	})
+	return db
+}
+
+// runConcurrently calls fn from workers goroutines at once.
+func runConcurrently(workers int, fn func(worker int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			<-start
+			fn(worker)
+		}(w)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestStateStoreGuildConcurrent(t *testing.T) {
+	store := &StateStore{guilds: make(map[string]*GuildState)}
+	got := make([]*GuildState, 16)
+	runConcurrently(16, func(worker int) {
+		got[worker] = store.Guild("g1")
+	})
+	for _, g := range got {
+		if g != got[0] {
+			t.Fatal("Guild returned different states for the same guild")
+		}
+	}
+}
+
+func TestGuildStateSchedulesConcurrent(t *testing.T) {
+	g := (&StateStore{guilds: make(map[string]*GuildState)}).Guild("g1")
+	runConcurrently(8, func(worker int) {
+		for i := 0; i < 200; i++ {
+			name := fmt.Sprintf("Class %d-%d", worker, i%10)
+			g.CacheSchedule(&ClassSchedule{GuildID: "g1", Name: name, StartTime: "09:00"})
+			if _, ok := g.Schedule(name); !ok {
+				t.Errorf("schedule %q missing right after caching it", name)
+				return
+			}
+			g.Schedules()
+			if i%3 == 0 {
+				g.UncacheSchedule(name)
+			}
+		}
+	})
+
+	for _, schedule := range g.Schedules() {
+		if _, ok := g.Schedule(schedule.Name); !ok {
+			t.Errorf("listed schedule %q cannot be looked up", schedule.Name)
+		}
+	}
+}
+
+func TestGuildStateVoiceSessionsConcurrent(t *testing.T) {
+	g := (&StateStore{guilds: make(map[string]*GuildState)}).Guild("g1")
+	runConcurrently(8, func(worker int) {
+		userID := fmt.Sprintf("user%d", worker)
+		for i := 0; i < 200; i++ {
+			g.WithVoiceSessions(func(sessions map[string]*VoiceSession) {
+				sessions[userID] = &VoiceSession{AttendanceID: int64(i), ChannelID: "c1"}
+			})
+			g.WithVoiceSessions(func(sessions map[string]*VoiceSession) {
+				if session := sessions[userID]; session == nil || session.AttendanceID != int64(i) {
+					t.Errorf("session of %s was changed by another goroutine", userID)
+				}
+				delete(sessions, userID)
+			})
+		}
+	})
+
+	g.WithVoiceSessions(func(sessions map[string]*VoiceSession) {
+		if len(sessions) != 0 {
+			t.Errorf("%d sessions left after every goroutine deleted its own", len(sessions))
+		}
+	})
+}
+
+func TestGuildSettingsConcurrent(t *testing.T) {
+	conn := useTestDatabase(t)
+	zones := []string{"UTC", "Asia/Bangkok", "Europe/Berlin", "America/New_York"}
+	runConcurrently(8, func(worker int) {
+		guildID := fmt.Sprintf("g%d", worker%4)
+		for i := 0; i < 25; i++ {
+			if err := setGuildTimezone(conn, guildID, zones[(worker+i)%len(zones)]); err != nil {
+				t.Error(err)
+				return
+			}
+			guildTimezone(guildID)
+			guildLocation(guildID)
+		}
+	})
+
+	for i := 0; i < 4; i++ {
+		guildID := fmt.Sprintf("g%d", i)
+		name := guildTimezone(guildID)
+		found := false
+		for _, zone := range zones {
+			found = found || zone == name
+		}
+		if !found {
+			t.Errorf("guild %s has unexpected timezone %q", guildID, name)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -13,57 +14,103 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var (
+	cfg *Config
+	db  *sql.DB
+)
+
 func main() {
+	configPath := flag.String("config", defaultConfigFile, "path to the YAML config file")
+	flag.Parse()
+	if v, ok := os.LookupEnv("BOT_CONFIG"); ok && !isFlagSet("config") {
+		*configPath = v
+	}
+
 	var err error
-	db, err = sql.Open("sqlite3", "./classroom.db")
-	if err != nil {
-		fmt.Println("Error opening database:", err)
+	if flag.Arg(0) == "auth" {
+		// One-time Google sign-in; needs only the Sheets settings.
+		var problems []string
+		cfg, problems, err = readConfig(*configPath)
+		if err == nil && len(problems) > 0 {
+			err = &ConfigError{Problems: problems}
+		}
+		if err == nil {
+			err = runAuth(os.Stdin, os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		return
 	}
-	defer db.Close()
-
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS attendance (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			guild_id TEXT,
-			user_id TEXT,
-			join_time DATETIME,
-			leave_time DATETIME,
-			voice_channel TEXT
-		)
-	`)
+
+	cfg, err = loadConfig(*configPath)
 	if err != nil {
-		fmt.Println("Error creating table:", err)
-		return
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	_, err = db.Exec(`
-        CREATE TABLE IF NOT EXISTS students (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            guild_id TEXT NOT NULL,
-            user_id TEXT NOT NULL,
-			username VARCHAR(32),
-            UNIQUE(guild_id, user_id) ON CONFLICT REPLACE
-        )
-    `)
+	err = openDatabases(cfg)
 	if err != nil {
-		fmt.Println("Error creating students table:", err)
+		fmt.Println(err)
 		return
 	}
+	defer closeDatabases()
 
-	classTimes = make(map[string]time.Time)
+	err = loadClassSchedules()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
-	dg, err := discordgo.New("Bot " + token)
+	dg, err := discordgo.New("Bot " + cfg.Token)
 	if err != nil {
 		fmt.Println("Error creating Discord session:", err)
 		return
 	}
 
-	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		messageCreate(s, m, db)
+	// Roster sync and `!setstudent` read guild members, a privileged intent.
+	dg.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentGuildMembers
+	if cfg.PrefixCommandsEnabled() {
+		// Prefix commands need the privileged message content intent.
+		dg.Identify.Intents |= discordgo.IntentMessageContent
+		dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
+			messageCreate(s, m)
+		})
+	}
+	dg.AddHandler(interactionCreate)
+	dg.AddHandler(reactionAdd)
+	dg.AddHandler(reactionRemove)
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Ready) {
+		if err := registerSlashCommands(s); err != nil {
+			log.Println(err)
+		}
 	})
 	dg.AddHandler(func(s *discordgo.Session, vs *discordgo.VoiceStateUpdate) {
-		voiceStateUpdate(s, vs, voiceStates, db)
+		voiceStateUpdate(s, vs, guildStates.Guild(vs.GuildID), guildDB(vs.GuildID))
+	})
+	dg.AddHandler(func(s *discordgo.Session, g *discordgo.GuildCreate) {
+		reconcileVoiceSessions(s, g.ID, g.VoiceStates, guildStates.Guild(g.ID), guildDB(g.ID))
+		go resumeAttendanceJobs(s, g.ID)
+		go func() {
+			changes, err := reconcileRoster(s, g.ID)
+			if err != nil {
+				log.Printf("Failed to sync roster for guild %s: %v", g.ID, err)
+			}
+			queueRosterSummary(s, g.ID, changes)
+		}()
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+		rosterMemberChanged(s, m.GuildID, m.User, m.Roles)
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+		rosterMemberChanged(s, m.GuildID, m.User, m.Roles)
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+		rosterMemberChanged(s, m.GuildID, m.User, nil)
+	})
+	dg.AddHandler(func(s *discordgo.Session, r *discordgo.Resumed) {
+		reconcileAllGuilds(s)
 	})
 
 	// dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -76,6 +123,8 @@ func main() {
 		return
 	}
 
+	go runHeartbeat(dg)
+
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic: %v\n", r)
@@ -84,11 +133,21 @@ func main() {
 
 	fmt.Println("Bot is now running. Press Ctrl+C to exit.")
 
-	<-make(chan struct{})
-
 	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
+	fmt.Println("Shutting down...")
+	sessionManager.Shutdown(10 * time.Second)
 	dg.Close()
 }
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
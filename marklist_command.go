@@ -1,320 +1,514 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	_ "github.com/mattn/go-sqlite3"
-	"google.golang.org/api/sheets/v4"
 )
 
 /*Content:
 -Mark list Now
 	-handleMarkListNow
+	-fetchChannelPresence
+	-memberDisplayName
 
 -Mark list Google Sheet
+	-handleMarkSheet
+	-handleMarkSheetStop
+	-handleMarkSheetStatus
 	-manageAttendanceSheet
 	-createNewSheet
 	-updateAttendanceSheet
 	-fetchStudents
 	-determineAttendance
+	-evaluateAttendance
 */
 
+// markListPerPage is the number of users per embed in `!marklistnow`.
+const markListPerPage = 30
+
 type Student struct {
-	UserID   string
-	Username string
+	UserID        string
+	Username      string
+	RealName      string
+	StudentNumber string
+	Section       string
 }
 
 // ===================================Mark list Now===========================================
-func handleMarkListNow(s *discordgo.Session, m *discordgo.MessageCreate, voiceChannelName string, timeStr string) {
-	currentDate := time.Now().UTC().Format("2006-01-02") // Ensures date is in UTC
-	dateTimeStr := currentDate + " " + timeStr
-
-	dateTimeParsed, err := time.Parse("2006-01-02 15:04", dateTimeStr) // Assuming timeStr is in UTC
+func handleMarkListNow(ctx *CommandContext, voiceChannelName string, timeStr string) {
+	dateTimeParsed, err := parseClockInLocation(timeStr, guildLocation(ctx.GuildID)) // timeStr is in the guild's timezone
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Invalid time format. Please use format HH:MM")
+		ctx.Reply("Invalid time format. Please use format HH:MM")
 		return
 	}
 
-	startTime := dateTimeParsed.Add(-10 * time.Minute) // Starts checking 10 minutes before the given time
-	endTime := dateTimeParsed.Add(90 * time.Minute)    // Ends checking 90 minutes after the given time
+	// Use the rules of the class held in this channel, if there is one
+	className := ""
+	duration := cfg.ClassDurationFor(ctx.GuildID)
+	for _, schedule := range guildClassSchedules(ctx.GuildID) {
+		if strings.EqualFold(schedule.VoiceChannel, voiceChannelName) {
+			className = schedule.Name
+			duration = schedule.Duration
+			break
+		}
+	}
+	policy := policyFor(ctx.GuildID, className)
+
+	startTime := dateTimeParsed.Add(-policy.PreWindow) // Starts checking the policy's pre-class window before the given time
+	endTime := dateTimeParsed.Add(duration)            // Ends checking when the class ends
 
-	query := `SELECT user_id FROM attendance WHERE join_time >= ? AND join_time <= ? AND voice_channel = ?`
-	rows, err := db.Query(query, startTime, endTime, voiceChannelName)
+	presence, err := fetchChannelPresence(guildDB(ctx.GuildID), ctx.GuildID, voiceChannelName, startTime, endTime)
 	if err != nil {
-		log.Println("Error querying database:", err)
-		s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again later.")
+		log.Println(err)
+		ctx.Reply("An error occurred. Please try again later.")
+		return
+	}
+	if len(presence) == 0 {
+		ctx.Reply("No users found in the specified time range.")
 		return
 	}
+
+	names := make(map[string]string)
+	if students, err := fetchStudents(guildDB(ctx.GuildID), ctx.GuildID); err != nil {
+		log.Println(err)
+	} else {
+		for _, student := range students {
+			names[student.UserID] = student.Name()
+		}
+	}
+
+	loc := guildLocation(ctx.GuildID)
+	var lines []string
+	for _, p := range presence {
+		name, ok := names[p.UserID]
+		if !ok {
+			name = memberDisplayName(ctx.Session, ctx.GuildID, p.UserID)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d min, joined %s", name, int(p.Present.Minutes()), p.FirstJoin.In(loc).Format("15:04")))
+	}
+
+	title := fmt.Sprintf("Attendance List (%d present)", len(lines))
+	for _, embed := range pagedEmbeds(title, lines, markListPerPage) {
+		ctx.ReplyEmbed(embed)
+	}
+}
+
+// ChannelPresence is the time one user spent in a voice channel during a
+// window, and when they first joined.
+type ChannelPresence struct {
+	UserID    string
+	Present   time.Duration
+	FirstJoin time.Time
+}
+
+// fetchChannelPresence returns every user who was in voiceChannel at some
+// point between start and end, ordered by first join. Each user's
+// attendance rows are clipped to the window and added up.
+func fetchChannelPresence(db *sql.DB, guildID, voiceChannel string, start, end time.Time) ([]ChannelPresence, error) {
+	rows, err := db.Query(`
+		SELECT user_id, join_time, leave_time
+		FROM attendance
+		WHERE guild_id = ? AND voice_channel = ? AND join_time < ? AND (leave_time IS NULL OR leave_time > ?)
+		ORDER BY join_time ASC
+	`, guildID, voiceChannel, end, start)
+	if err != nil {
+		return nil, fmt.Errorf("error querying database: %v", err)
+	}
 	defer rows.Close()
 
-	var userList []string
+	const layout = time.RFC3339Nano
+	byUser := make(map[string]*ChannelPresence)
+	var presence []*ChannelPresence
 	for rows.Next() {
 		var userID string
-		if err := rows.Scan(&userID); err != nil {
-			log.Println("Error scanning row:", err)
-			continue
+		var joinTimeStr, leaveTimeStr sql.NullString
+		if err := rows.Scan(&userID, &joinTimeStr, &leaveTimeStr); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		joinTime, err := time.Parse(layout, joinTimeStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing join time: %v", err)
+		}
+		leaveTime := time.Now().UTC() // Still in the channel
+		if leaveTimeStr.Valid {
+			if leaveTime, err = time.Parse(layout, leaveTimeStr.String); err != nil {
+				return nil, fmt.Errorf("error parsing leave time: %v", err)
+			}
+		}
+
+		p, ok := byUser[userID]
+		if !ok {
+			p = &ChannelPresence{UserID: userID, FirstJoin: joinTime}
+			byUser[userID] = p
+			presence = append(presence, p)
+		}
+		// Only count the part inside the window
+		if joinTime.Before(start) {
+			joinTime = start
+		}
+		if leaveTime.After(end) {
+			leaveTime = end
+		}
+		if overlap := leaveTime.Sub(joinTime); overlap > 0 {
+			p.Present += overlap
 		}
-		userList = append(userList, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rows: %v", err)
 	}
 
-	if len(userList) == 0 {
-		s.ChannelMessageSend(m.ChannelID, "No users found in the specified time range.")
-		return
+	result := make([]ChannelPresence, 0, len(presence))
+	for _, p := range presence {
+		if p.Present > 0 {
+			result = append(result, *p)
+		}
 	}
+	return result, nil
+}
 
-	var userNames []string
-	for _, userID := range userList {
-		user, err := s.User(userID)
-		if err != nil {
-			log.Println("Error getting user info:", err)
-			continue
+// memberDisplayName returns the server nickname or username of userID from
+// the session's member cache, or a mention when the member is not cached.
+func memberDisplayName(s *discordgo.Session, guildID, userID string) string {
+	member, err := s.State.Member(guildID, userID)
+	if err != nil || member.User == nil {
+		return "<@" + userID + ">"
+	}
+	if member.Nick != "" {
+		return member.Nick
+	}
+	return member.User.Username
+}
+
+// ===================================Mark list Google Sheet===========================================
+// handleMarkSheet starts attendance tracking into sheetName using the class
+// schedule of the same name (or the guild's only class). With now set, the
+// class starts at the current time instead.
+func handleMarkSheet(ctx *CommandContext, sheetName string, now bool) {
+	schedule, found := scheduleForSheet(ctx.GuildID, sheetName)
+	if now {
+		adHoc := &ClassSchedule{
+			GuildID:  ctx.GuildID,
+			Name:     sheetName,
+			Duration: cfg.ClassDurationFor(ctx.GuildID),
+			Timezone: guildTimezone(ctx.GuildID),
+		}
+		if found {
+			adHoc.Duration = schedule.Duration
+			adHoc.Timezone = schedule.Timezone
+			adHoc.VoiceChannel = schedule.VoiceChannel
 		}
-		userNames = append(userNames, user.Username)
+		adHoc.StartTime = time.Now().In(adHoc.Location()).Format("15:04")
+		schedule = adHoc
+	} else if !found {
+		ctx.Reply(fmt.Sprintf("Class time not found for '%s'. Set one with `!setclasstime [time] %s`.", sheetName, sheetName))
+		return
+	} else if !schedule.MeetsOn(time.Now()) {
+		ctx.Reply(fmt.Sprintf("'%s' does not meet today (%s). Use `!ms now %s` to track it anyway.",
+			schedule.Name, schedule.WeekdaysString(), sheetName))
+		return
+	}
+
+	ctx.Defer()
+	manageAttendanceSheet(ctx, sheetName, schedule)
+	if now {
+		ctx.Reply(fmt.Sprintf("Class time for '%s' updated to current time: %s %s", sheetName, schedule.StartTime, schedule.Timezone))
+	}
+}
+
+// handleMarkSheetStop stops the updates of sheetName, or of every sheet of the
+// guild when sheetName is empty.
+func handleMarkSheetStop(ctx *CommandContext, sheetName string) {
+	stopped := sessionManager.Stop(ctx.GuildID, sheetName, time.Now().UTC(), ctx.Author.ID)
+	if len(stopped) == 0 {
+		ctx.Reply("No attendance updates are running.")
+		return
+	}
+	var names []string
+	for _, job := range stopped {
+		names = append(names, job.SheetName)
+	}
+	ctx.Reply(fmt.Sprintf("Attendance updates have been stopped: %s.", strings.Join(names, ", ")))
+}
+
+// handleMarkSheetStatus lists the guild's running attendance jobs.
+func handleMarkSheetStatus(ctx *CommandContext) {
+	jobs := sessionManager.Jobs(ctx.GuildID)
+	if len(jobs) == 0 {
+		ctx.Reply("No attendance updates are running.")
+		return
 	}
 
 	embed := &discordgo.MessageEmbed{
-		Title: "Attendance List",
+		Title: "Attendance Updates",
 		Color: 0x00ff00, // Green color
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Users Present",
-				Value:  strings.Join(userNames, "\n"),
-				Inline: false,
-			},
-		},
-	}
-	s.ChannelMessageSendEmbed(m.ChannelID, embed)
+	}
+	for _, job := range jobs {
+		loc := job.Class.Location()
+		session := job.Session()
+		value := fmt.Sprintf("Class: %s\nStarted: %s\nClass ends: %s",
+			job.Class.Name,
+			job.StartedAt.In(loc).Format("2006-01-02 15:04 MST"),
+			session.End().In(loc).Format("2006-01-02 15:04 MST"))
+		if job.Stopped() {
+			value += " (stopped)"
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   job.SheetName,
+			Value:  value,
+			Inline: false,
+		})
+	}
+	ctx.ReplyEmbed(embed)
 }
 
-// ===================================Mark list Google Sheet===========================================
-func manageAttendanceSheet(s *discordgo.Session, m *discordgo.MessageCreate, sheetName string) {
+func manageAttendanceSheet(ctx *CommandContext, sheetName string, class *ClassSchedule) {
 	if sheetName == "" {
-		s.ChannelMessageSend(m.ChannelID, "Sheet name cannot be empty.")
+		ctx.Reply("Sheet name cannot be empty.")
 		log.Println("Attempted to access a sheet with an empty name.")
 		return
 	}
 
-	srv, err := initSheetsService()
+	exporter, err := newExporter(ctx.GuildID)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to initialize Google Sheets service: %v", err))
-		log.Printf("Failed to initialize Google Sheets service: %v\n", err)
+		ctx.Reply(fmt.Sprintf("Failed to set up the attendance exporter: %v", err))
+		log.Printf("Failed to set up the attendance exporter: %v\n", err)
 		return
 	}
 
-	spreadsheet, err := srv.Spreadsheets.Get(spreadsheetID).Do()
+	found, err := exporter.SheetExists(sheetName)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to access the spreadsheet: %v", err))
+		ctx.Reply(fmt.Sprintf("Failed to access the spreadsheet: %v", err))
 		log.Printf("Failed to access the spreadsheet: %v\n", err)
 		return
 	}
 
-	found := false
-	for _, sheet := range spreadsheet.Sheets {
-		if sheet.Properties.Title == sheetName {
-			found = true
-			break
-		}
+	db := guildDB(ctx.GuildID)
+	session := newClassSession(ctx.GuildID, ctx.ChannelID, sheetName, class)
+	if err := insertClassSession(db, session); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to record the class session.")
+		return
 	}
+	job := newAttendanceJob(session, class)
 
 	if !found {
 		log.Printf("Sheet not found, creating a new one: %s\n", sheetName)
-		createNewSheet(s, m, srv, sheetName)
+		if !createNewSheet(ctx, exporter, sheetName) {
+			finishAttendanceJob(db, job, stoppedBySchedule)
+			return
+		}
 	} else {
 		log.Printf("Successfully accessed sheet: %s\n", sheetName)
-		updateAttendanceSheet(s, m, srv, sheetName, m.GuildID)
 	}
-	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Successfully accessed sheet: %s\n", sheetName))
-	s.ChannelMessageSend(m.ChannelID, "Sheet updated successfully with new attendance marks.")
+	ctx.Reply(fmt.Sprintf("Successfully accessed sheet: %s\n", sheetName))
 
 	// endTime := classTimes[m.GuildID].Add(classDuration)
 	// remainingTime := time.Until(endTime)
 
-	if updateDuration[m.GuildID] {
-		endTime := classTimes[m.GuildID].Add(classDuration)
-		remainingTime := time.Until(endTime)
-
-		if remainingTime > 0 {
-			ticker := time.NewTicker(1 * time.Minute)
-			endTimer := time.NewTimer(remainingTime)
-			go func() {
-				for {
-					select {
-					case <-ticker.C:
-						if !updateDuration[m.GuildID] {
-							ticker.Stop()
-							updateAttendanceSheet(s, m, srv, sheetName, m.GuildID)
-							log.Println("Update halted as per command.")
-							return
-						}
-						updateAttendanceSheet(s, m, srv, sheetName, m.GuildID)
-					case <-endTimer.C:
-						ticker.Stop()
-						log.Println("Class ended, stopping attendance updates.")
-						return
-					}
-				}
-			}()
-		} else {
-			log.Println("Class time has already passed, no attendance updates needed.")
+	if time.Until(session.ScheduledEnd) > 0 {
+		if replaced := startAttendanceJob(ctx, exporter, job); replaced != nil {
+			ctx.Reply(fmt.Sprintf("Restarted the attendance updates that were already running for '%s'.", sheetName))
 		}
+	} else {
+		log.Println("Class time has already passed, no attendance updates needed.")
+		finishAttendanceJob(db, job, stoppedBySchedule)
 	}
 
 	log.Printf("Attendance monitoring started for %s", sheetName)
-	updateAttendanceSheet(s, m, srv, sheetName, m.GuildID)
+	if updateAttendanceSheet(ctx, exporter, job.Session()) {
+		replyWithExport(ctx, exporter, sheetName, "Sheet updated successfully with new attendance marks.")
+	}
 }
 
-func createNewSheet(s *discordgo.Session, m *discordgo.MessageCreate, srv *sheets.Service, sheetName string) {
-	// Fetch student data
-	students, err := fetchStudents(db, m.GuildID)
+// startAttendanceJob updates the job's sheet every minute until its class
+// ends or the job is stopped, and returns the job it replaced, if any.
+func startAttendanceJob(ctx *CommandContext, exporter AttendanceExporter, job *AttendanceJob) *AttendanceJob {
+	db := guildDB(ctx.GuildID)
+	sheetName := job.SheetName
+	remainingTime := time.Until(job.Session().ScheduledEnd)
+	return sessionManager.Start(job, func(jobCtx context.Context, job *AttendanceJob) {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		endTimer := time.NewTimer(remainingTime)
+		defer endTimer.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				updateAttendanceSheet(ctx, exporter, job.Session())
+			case <-jobCtx.Done():
+				session := job.finish(time.Now().UTC(), stoppedByShutdown)
+				if session.StoppedBy != stoppedByShutdown && session.StoppedBy != stoppedByReplaced {
+					if updateAttendanceSheet(ctx, exporter, session) {
+						replyWithExport(ctx, exporter, sheetName, "Final attendance marks for "+sheetName+".")
+					}
+					log.Println("Update halted as per command.")
+				}
+				if err := closeClassSession(db, &session); err != nil {
+					log.Println(err)
+				}
+				return
+			case <-endTimer.C:
+				log.Println("Class ended, stopping attendance updates.")
+				finishAttendanceJob(db, job, stoppedBySchedule)
+				return
+			}
+		}
+	})
+}
+
+// resumeAttendanceJobs restarts the attendance updates of classes that were
+// still running when the bot stopped. Replies go to the channel tracking was
+// started from.
+func resumeAttendanceJobs(s *discordgo.Session, guildID string) {
+	db := guildDB(guildID)
+	sessions, err := fetchUnfinishedSessions(db, guildID, time.Now().UTC())
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Failed to fetch student data: "+err.Error())
+		log.Printf("Failed to resume attendance updates for guild %s: %v", guildID, err)
 		return
 	}
+	running := make(map[int64]bool)
+	for _, job := range sessionManager.Jobs(guildID) {
+		running[job.Session().ID] = true
+	}
+
+	for i := range sessions {
+		session := &sessions[i]
+		if running[session.ID] {
+			continue
+		}
+		class := &ClassSchedule{
+			GuildID:      guildID,
+			Name:         session.ClassName,
+			StartTime:    session.ScheduledStart.In(guildLocation(guildID)).Format("15:04"),
+			Duration:     session.ScheduledEnd.Sub(session.ScheduledStart),
+			Timezone:     guildTimezone(guildID),
+			VoiceChannel: session.VoiceChannel,
+		}
+		if schedule, ok := findClassSchedule(guildID, session.ClassName); ok {
+			class.Timezone = schedule.Timezone
+			class.Weekdays = schedule.Weekdays
+			class.StartTime = session.ScheduledStart.In(schedule.Location()).Format("15:04")
+		}
 
-	// Define the request to add a new sheet to the existing spreadsheet
-	addSheetRequest := &sheets.AddSheetRequest{
-		Properties: &sheets.SheetProperties{
-			Title: sheetName,
-		},
+		exporter, err := newExporter(guildID)
+		if err != nil {
+			log.Printf("Failed to set up the attendance exporter: %v\n", err)
+			return
+		}
+		if err := reopenClassSession(db, session); err != nil {
+			log.Println(err)
+			continue
+		}
+		ctx := &CommandContext{Session: s, GuildID: guildID, ChannelID: session.ChannelID}
+		startAttendanceJob(ctx, exporter, newAttendanceJob(session, class))
+		log.Printf("Resumed attendance updates for %s", session.SheetName)
 	}
-	batchUpdateRequest := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: []*sheets.Request{
-			{
-				AddSheet: addSheetRequest,
-			},
-		},
+}
+
+// finishAttendanceJob closes the job's class session at its scheduled end.
+func finishAttendanceJob(db *sql.DB, job *AttendanceJob, stoppedBy string) {
+	session := job.finish(job.Session().ScheduledEnd, stoppedBy)
+	if err := closeClassSession(db, &session); err != nil {
+		log.Println(err)
 	}
+}
 
-	// Execute the batch update to add the new sheet
-	resp, err := srv.Spreadsheets.BatchUpdate(spreadsheetID, batchUpdateRequest).Do()
+// replyWithExport sends content, attaching the exported sheet when the
+// exporter is file based.
+func replyWithExport(ctx *CommandContext, exporter AttendanceExporter, sheetName, content string) {
+	file, err := exporter.File(sheetName)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to create new sheet: %v", err))
-		log.Printf("Failed to create new sheet: %v", err)
+		log.Printf("Failed to attach exported sheet %s: %v", sheetName, err)
+	}
+	if file == nil {
+		ctx.Reply(content)
 		return
 	}
+	ctx.ReplyFile(content, file)
+}
 
-	// Extract the sheet ID of the newly created sheet
-	newSheetID := resp.Replies[0].AddSheet.Properties.SheetId
-
-	// Construct URL to the newly created sheet
-	sheetURL := fmt.Sprintf("https://docs.google.com/spreadsheets/d/%s/edit#gid=%d", spreadsheetID, newSheetID)
-
-	// Append header to the new sheet
-	headerValues := []interface{}{"Number", "Username", "Mark " + time.Now().Format("02/01/2006")}
-	vr := &sheets.ValueRange{
-		Values: [][]interface{}{headerValues},
-	}
-	_, err = srv.Spreadsheets.Values.Append(spreadsheetID, sheetName+"!A1", vr).ValueInputOption("USER_ENTERED").Do()
+func createNewSheet(ctx *CommandContext, exporter AttendanceExporter, sheetName string) bool {
+	// Fetch student data
+	students, err := fetchStudents(guildDB(ctx.GuildID), ctx.GuildID)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Unable to append header to new sheet: %v", err))
-		log.Printf("Unable to append header to new sheet: %v", err)
-		return
+		ctx.Reply("Failed to fetch student data: " + err.Error())
+		return false
 	}
 
-	// Append initial student data to the new sheet
-	valueRange := sheetName + "!A2:B" + strconv.Itoa(len(students)+1)
-	var data [][]interface{}
-	for i, student := range students {
-		row := []interface{}{i + 1, student.Username}
-		data = append(data, row)
-	}
-	vr.Values = data
-	_, err = srv.Spreadsheets.Values.Append(spreadsheetID, valueRange, vr).ValueInputOption("USER_ENTERED").Do()
+	location, err := exporter.CreateRosterSheet(sheetName, students)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to append student data to new sheet: %v", err))
-		log.Printf("Failed to append student data to new sheet: %v", err)
-		return
+		ctx.Reply(fmt.Sprintf("Failed to create new sheet: %v", err))
+		log.Printf("Failed to create new sheet: %v", err)
+		return false
 	}
 
-	// Notify the user about the successful creation and provide the link to the new sheet
-	s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("New sheet '%s' created and initialized successfully. You can access it here: %s", sheetName, sheetURL))
+	// Notify the user about the successful creation and tell them where to find it
+	ctx.Reply(fmt.Sprintf("New sheet '%s' created and initialized successfully. You can access it here: %s", sheetName, location))
+	return true
 }
 
-func updateAttendanceSheet(s *discordgo.Session, m *discordgo.MessageCreate, srv *sheets.Service, sheetName, guildID string) {
-	students, err := fetchStudents(db, guildID)
+// updateAttendanceSheet writes the session's marks and reports whether it succeeded.
+func updateAttendanceSheet(ctx *CommandContext, exporter AttendanceExporter, session ClassSession) bool {
+	guildID, sheetName := session.GuildID, session.SheetName
+
+	students, err := fetchStudents(guildDB(guildID), guildID)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Failed to fetch student data: "+err.Error())
-		return
+		ctx.Reply("Failed to fetch student data: " + err.Error())
+		return false
 	}
 
-	currentDate := time.Now().UTC().Format("2006-01-02") // Ensuring the date is in UTC
-	dateColumn := "Mark " + currentDate
-	classTime, exists := classTimes[guildID]
-	if !exists {
-		s.ChannelMessageSend(m.ChannelID, "Class time not found.")
-		return
-	}
+	dateColumn := "Mark " + session.Date
 
 	// Calculate the start and potentially adjusted end times
-	newClassTime := time.Date(time.Now().UTC().Year(), time.Now().UTC().Month(), time.Now().UTC().Day(),
-		classTime.Hour(), classTime.Minute(), classTime.Second(), classTime.Nanosecond(), time.UTC)
-	startTime := newClassTime.Add(-10 * time.Minute) // Start time is 10 minutes before the recorded start time
-
-	var endTime time.Time
-	var classDurationSet time.Duration
-	if classEndTimes, adjusted := classEndTimes[guildID]; adjusted {
-		endTime = classEndTimes
-		classDurationSet = endTime.Sub(startTime) // Use the time from the start to the adjusted end as the class duration
-	} else {
-		endTime = newClassTime.Add(classDuration) // Use the default duration if not adjusted
-		classDurationSet = classDuration
-	}
+	policy := policyFor(guildID, session.ClassName)
+	startTime := session.ScheduledStart.Add(-policy.PreWindow) // Joining counts from the policy's pre-class window
+	endTime := session.End()                                   // The scheduled end, unless the session was stopped
 
 	startTimeStr := startTime.Format(time.RFC3339Nano)
 	endTimeStr := endTime.Format(time.RFC3339Nano)
 	log.Printf("Class Start time: %v, Adjusted End time: %v", startTimeStr, endTimeStr)
 
-	// Continue processing to check header row existence and manage the sheet columns
-	headerResp, err := srv.Spreadsheets.Values.Get(spreadsheetID, sheetName+"!1:1").Do()
+	// Place students by user ID; new students get a row, departed ones are flagged
+	rowOf, err := exporter.SyncRoster(sheetName, students)
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to retrieve header row: %v", err))
-		return
-	}
-
-	headerExists := false
-	columnIndex := len(headerResp.Values[0])
-	for index, headerValue := range headerResp.Values[0] {
-		if headerValue.(string) == dateColumn {
-			headerExists = true
-			columnIndex = index
-			break
-		}
+		exportFailed(ctx, guildID, err)
+		return false
 	}
 
-	if !headerExists {
-		newColumnRange := sheetName + fmt.Sprintf("!R1C%d", columnIndex+1)
-		vr := &sheets.ValueRange{Values: [][]interface{}{{dateColumn}}}
-		_, err = srv.Spreadsheets.Values.Update(spreadsheetID, newColumnRange, vr).ValueInputOption("USER_ENTERED").Do()
-		if err != nil {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to add new date column: %v", err))
-			return
-		}
+	columnIndex, err := exporter.UpsertDateColumn(sheetName, dateColumn)
+	if err != nil {
+		exportFailed(ctx, guildID, err)
+		return false
 	}
 
 	// Update the sheet with the attendance statuses
-	values := make([][]interface{}, len(students))
-	for i, student := range students {
-		status := determineAttendance(db, student.UserID, guildID, startTime, endTime, classDurationSet)
-		values[i] = []interface{}{status}
+	marks := make(map[int]string, len(students))
+	for _, student := range students {
+		marks[rowOf[student.UserID]] = determineAttendance(guildDB(guildID), student.UserID, session, policy)
 	}
-
-	if len(values) > 0 {
-		dataRange := fmt.Sprintf("%s!R2C%d:R%dC%d", sheetName, columnIndex+1, len(students)+1, columnIndex+1)
-		vr := &sheets.ValueRange{Values: values}
-		_, err = srv.Spreadsheets.Values.Update(spreadsheetID, dataRange, vr).ValueInputOption("USER_ENTERED").Do()
-		if err != nil {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Failed to update sheet: %v", err))
-			return
-		}
+	if err := exporter.WriteMarks(sheetName, columnIndex, marks); err != nil {
+		exportFailed(ctx, guildID, err)
+		return false
 	}
+	clearSheetsFailure(ctx.Session, guildID)
 
 	log.Printf("Sheet updated successfully with new attendance marks.")
+	return true
+}
+
+// exportFailed reports a failed update to the admins, and tells the class
+// channel only the first time so ticker retries do not flood it.
+func exportFailed(ctx *CommandContext, guildID string, err error) {
+	if reportSheetsFailure(ctx.Session, guildID, err) {
+		ctx.Reply("Failed to update the attendance sheet. It will be retried every minute while the class runs.")
+	}
 }
 
 /*
@@ -396,8 +590,20 @@ func updateAttendanceSheet(s *discordgo.Session, m *discordgo.MessageCreate, srv
 	}
 */
 func fetchStudents(db *sql.DB, guildID string) ([]Student, error) {
-	query := `SELECT user_id, username FROM students WHERE guild_id = ?`
-	rows, err := db.Query(query, guildID)
+	return queryStudents(db, guildID, true)
+}
+
+// fetchStoredStudents returns every student of guildID, removed ones included.
+func fetchStoredStudents(db *sql.DB, guildID string) ([]Student, error) {
+	return queryStudents(db, guildID, false)
+}
+
+func queryStudents(db *sql.DB, guildID string, activeOnly bool) ([]Student, error) {
+	query := `SELECT user_id, username, real_name, student_number, section FROM students WHERE guild_id = ?`
+	if activeOnly {
+		query += ` AND active = 1`
+	}
+	rows, err := db.Query(query+` ORDER BY id`, guildID)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching students from database: %v", err)
 	}
@@ -406,7 +612,7 @@ func fetchStudents(db *sql.DB, guildID string) ([]Student, error) {
 	var students []Student
 	for rows.Next() {
 		var student Student
-		if err := rows.Scan(&student.UserID, &student.Username); err != nil {
+		if err := rows.Scan(&student.UserID, &student.Username, &student.RealName, &student.StudentNumber, &student.Section); err != nil {
 			return nil, fmt.Errorf("error reading student data: %v", err)
 		}
 		students = append(students, student)
@@ -418,42 +624,93 @@ func fetchStudents(db *sql.DB, guildID string) ([]Student, error) {
 	return students, nil
 }
 
-func determineAttendance(db *sql.DB, userID, guildID string, classStartTimeUTC, classEndTimeUTC time.Time, classDurationSet time.Duration) string {
-	var rows *sql.Rows
-	var err error
+// Attendance statuses of a student in one class session.
+const (
+	attendancePresent    = "present"
+	attendanceLate       = "late"
+	attendanceEarlyLeave = "early leave"
+	attendanceAbsent     = "absent"
+)
+
+// AttendanceResult is how a student attended one class session.
+type AttendanceResult struct {
+	Status  string
+	Percent int           // Share of the class attended, capped at 100
+	Late    time.Duration // How long after the start they first joined, when late
+}
+
+// Mark formats the result for the attendance sheet with the labels of policy.
+func (r AttendanceResult) Mark(policy AttendancePolicy) string {
+	switch r.Status {
+	case attendanceLate:
+		minutes := int(r.Late.Minutes())
+		seconds := int(r.Late.Seconds()) % 60
+		return fmt.Sprintf("%s %dm%ds %d%%", policy.LateLabel, minutes, seconds, r.Percent)
+	case attendanceEarlyLeave:
+		return fmt.Sprintf("%s %d%%", policy.EarlyLeaveLabel, r.Percent)
+	case attendanceAbsent:
+		return fmt.Sprintf("%s %d%%", policy.AbsentLabel, r.Percent)
+	default:
+		return fmt.Sprintf("%s %d%%", policy.PresentLabel, r.Percent)
+	}
+}
+
+// determineAttendance computes a student's mark for a class session, or ""
+// when the attendance data cannot be read.
+func determineAttendance(db *sql.DB, userID string, session ClassSession, policy AttendancePolicy) string {
+	result, err := evaluateAttendance(db, userID, session, policy)
+	if err != nil {
+		log.Println(err)
+		return "" // Error state
+	}
+	return result.Mark(policy)
+}
+
+// evaluateAttendance works out how a student attended a class session, from
+// its scheduled start until it was stopped or scheduled to end. Voice time is
+// counted from policy.PreWindow before the start.
+func evaluateAttendance(db *sql.DB, userID string, session ClassSession, policy AttendancePolicy) (AttendanceResult, error) {
+	guildID := session.GuildID
+	classStartTimeUTC, classEndTimeUTC := session.ScheduledStart, session.End()
+	windowStart := classStartTimeUTC.Add(-policy.PreWindow)
+
+	// Only time in the class's own voice channel counts, when it has one
+	channelFilter := ""
+	args := []interface{}{userID, guildID, classEndTimeUTC, windowStart}
+	if session.VoiceChannel != "" {
+		channelFilter = " AND voice_channel = ?"
+		args = append(args, session.VoiceChannel)
+	}
 	query := `
         SELECT join_time, leave_time
         FROM attendance
-        WHERE user_id = ? AND guild_id = ? AND join_time < ? AND (leave_time IS NULL OR leave_time > ?)
+        WHERE user_id = ? AND guild_id = ? AND join_time < ? AND (leave_time IS NULL OR leave_time > ?)` + channelFilter + `
         ORDER BY join_time ASC
     `
-	rows, err = db.Query(query, userID, guildID, classEndTimeUTC, classStartTimeUTC)
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		log.Printf("Error querying attendance data: %v", err)
-		return "" // Error state
+		return AttendanceResult{}, fmt.Errorf("error querying attendance data: %v", err)
 	}
 	defer rows.Close()
 
 	totalAttendedDuration := time.Duration(0)
-	var earliestJoinTime time.Time
+	var earliestJoinTime, lastLeaveTime time.Time
+	stillPresent := false
 
 	const layout = time.RFC3339Nano // Time layout for parsing
 	isFirst := true
 
 	for rows.Next() {
 		var joinTimeStr, leaveTimeStr sql.NullString
-		err = rows.Scan(&joinTimeStr, &leaveTimeStr)
-		if err != nil {
-			log.Printf("Error scanning attendance data: %v", err)
-			return "" // Error state
+		if err := rows.Scan(&joinTimeStr, &leaveTimeStr); err != nil {
+			return AttendanceResult{}, fmt.Errorf("error scanning attendance data: %v", err)
 		}
 
 		var joinTime, leaveTime time.Time
 		if joinTimeStr.Valid {
 			joinTime, err = time.Parse(layout, joinTimeStr.String)
 			if err != nil {
-				log.Printf("Error parsing join time: %v", err)
-				return "" // Invalid join time format
+				return AttendanceResult{}, fmt.Errorf("error parsing join time: %v", err)
 			}
 			if isFirst || joinTime.Before(earliestJoinTime) {
 				earliestJoinTime = joinTime
@@ -464,20 +721,23 @@ func determineAttendance(db *sql.DB, userID, guildID string, classStartTimeUTC,
 		if leaveTimeStr.Valid {
 			leaveTime, err = time.Parse(layout, leaveTimeStr.String)
 			if err != nil {
-				log.Printf("Error parsing leave time: %v", err)
-				return "" // Invalid leave time format
+				return AttendanceResult{}, fmt.Errorf("error parsing leave time: %v", err)
 			}
-		} else {
-			leaveTimeNull := time.Date(time.Now().UTC().Year(), time.Now().UTC().Month(), time.Now().UTC().Day(),
-				time.Now().UTC().Hour(), time.Now().UTC().Minute(), time.Now().UTC().Second(), time.Now().UTC().Nanosecond(), time.UTC)
-			leaveTimeNullStr := leaveTimeNull.Format(layout)
-			leaveTime, err = time.Parse(layout, leaveTimeNullStr)
-			if err != nil {
-				log.Printf("Error parsing leave time: %v", err)
-				return "" // Invalid leave time format
+			if leaveTime.After(lastLeaveTime) {
+				lastLeaveTime = leaveTime
 			}
+		} else {
+			leaveTime = time.Now().UTC() // Still in the channel
+			stillPresent = true
 		}
 
+		// Only count the part of the session inside the class window
+		if joinTime.Before(windowStart) {
+			joinTime = windowStart
+		}
+		if leaveTime.After(classEndTimeUTC) {
+			leaveTime = classEndTimeUTC
+		}
 		duration := leaveTime.Sub(joinTime)
 		if duration > 0 {
 			totalAttendedDuration += duration
@@ -485,34 +745,39 @@ func determineAttendance(db *sql.DB, userID, guildID string, classStartTimeUTC,
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error processing rows: %v", err)
-		return "" // Error state
+		return AttendanceResult{}, fmt.Errorf("error processing rows: %v", err)
 	}
 
 	if totalAttendedDuration <= 0 {
-		return "A 0%" // Absent
+		return AttendanceResult{Status: attendanceAbsent}, nil
 	}
 
 	// Calculations for attendance duration and percentage
 	attendedMinutes := totalAttendedDuration.Minutes()
-	totalMinutes := classDurationSet.Minutes()
+	totalMinutes := classEndTimeUTC.Sub(classStartTimeUTC).Minutes()
+	if totalMinutes <= 0 {
+		totalMinutes = 1
+	}
 	attendancePercentage := int((attendedMinutes / totalMinutes) * 100)
 	if attendancePercentage > 100 {
-		attendancePercentage = 100 // Cap at 100% if there's overlapping time
+		attendancePercentage = 100 // Cap at 100% since the pre-class window also counts
 	}
 
-	var status string
-	adjClassStart := classStartTimeUTC.Add(20 * time.Minute) // 10 minutes grace period *note classtartime is already -10m
-	if earliestJoinTime.After(adjClassStart) {
-		lateDuration := earliestJoinTime.Sub(adjClassStart.Add(-10 * time.Minute)) // Return 10 minutes back to class start time
-		minutes := int(lateDuration.Minutes())
-		seconds := int(lateDuration.Seconds()) % 60
-		status = fmt.Sprintf("L %dm%ds %d%%", minutes, seconds, attendancePercentage)
-	} else {
-		status = fmt.Sprintf("X %d%%", attendancePercentage)
+	result := AttendanceResult{Status: attendancePresent, Percent: attendancePercentage}
+	lateAfter := classStartTimeUTC.Add(policy.LateThreshold)
+	leftEarly := policy.EarlyLeaveThreshold > 0 && !stillPresent &&
+		lastLeaveTime.Before(classEndTimeUTC.Add(-policy.EarlyLeaveThreshold))
+	switch {
+	case attendancePercentage < policy.MinPresencePercent:
+		result.Status = attendanceAbsent
+	case earliestJoinTime.After(lateAfter):
+		result.Status = attendanceLate
+		result.Late = earliestJoinTime.Sub(classStartTimeUTC)
+	case leftEarly:
+		result.Status = attendanceEarlyLeave
 	}
 
-	return status
+	return result, nil
 }
 
 // // If the join time is after the class has ended, return absent
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// insertAttendance records a stay in a voice channel the way voice events
+// do. A zero leave time leaves the user in the channel.
+func insertAttendance(t *testing.T, conn *sql.DB, userID, channel string, join, leave time.Time) {
+	t.Helper()
+	var leaveTime interface{}
+	if !leave.IsZero() {
+		leaveTime = leave.UTC()
+	}
+	_, err := conn.Exec("INSERT INTO attendance (guild_id, user_id, join_time, leave_time, voice_channel) VALUES (?, ?, ?, ?, ?)",
+		"g1", userID, join.UTC(), leaveTime, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluateAttendanceVoiceChannel(t *testing.T) {
+	conn := useTestDatabase(t)
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	insertAttendance(t, conn, "inClass", "Math Room", start, end)
+	insertAttendance(t, conn, "elsewhere", "Lounge", start, end)
+
+	policy := AttendancePolicy{LateThreshold: 5 * time.Minute, MinPresencePercent: 50}
+	session := ClassSession{GuildID: "g1", ScheduledStart: start, ScheduledEnd: end, VoiceChannel: "Math Room"}
+	anyChannel := session
+	anyChannel.VoiceChannel = ""
+
+	tests := []struct {
+		name    string
+		userID  string
+		session ClassSession
+		want    string
+	}{
+		{"class channel", "inClass", session, attendancePresent},
+		{"other channel", "elsewhere", session, attendanceAbsent},
+		{"no channel set", "elsewhere", anyChannel, attendancePresent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := evaluateAttendance(conn, tt.userID, tt.session, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != tt.want {
+				t.Errorf("status = %q, want %q", result.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchChannelPresence(t *testing.T) {
+	conn := useTestDatabase(t)
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	insertAttendance(t, conn, "spansStart", "Math Room", at(-15), at(20))
+	insertAttendance(t, conn, "stillOpen", "Math Room", at(30), time.Time{})
+	insertAttendance(t, conn, "several", "Math Room", at(5), at(15))
+	insertAttendance(t, conn, "several", "Math Room", at(40), at(50))
+	insertAttendance(t, conn, "several", "Math Room", at(55), at(90))
+	insertAttendance(t, conn, "elsewhere", "Lounge", at(0), at(60))
+	insertAttendance(t, conn, "afterwards", "Math Room", at(65), at(70))
+
+	got, err := fetchChannelPresence(conn, "g1", "Math Room", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ChannelPresence{
+		{UserID: "spansStart", Present: 20 * time.Minute, FirstJoin: at(-15)},
+		{UserID: "several", Present: 25 * time.Minute, FirstJoin: at(5)},
+		{UserID: "stillOpen", Present: 30 * time.Minute, FirstJoin: at(30)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("presence = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].UserID != want[i].UserID || got[i].Present != want[i].Present || !got[i].FirstJoin.Equal(want[i].FirstJoin) {
+			t.Errorf("presence[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ==================================PERMISSIONS===========================================
+// PermissionLevel is the access a command requires. Each level includes the
+// ones below it, so an admin may run teacher commands.
+type PermissionLevel int
+
+const (
+	PermissionEveryone PermissionLevel = iota
+	PermissionTA
+	PermissionTeacher
+	PermissionAdmin
+)
+
+var permissionLevelNames = map[PermissionLevel]string{
+	PermissionEveryone: "everyone",
+	PermissionTA:       "ta",
+	PermissionTeacher:  "teacher",
+	PermissionAdmin:    "admin",
+}
+
+func (l PermissionLevel) String() string {
+	return permissionLevelNames[l]
+}
+
+func parsePermissionLevel(name string) (PermissionLevel, bool) {
+	for level, levelName := range permissionLevelNames {
+		if level != PermissionEveryone && strings.EqualFold(levelName, name) {
+			return level, true
+		}
+	}
+	return PermissionEveryone, false
+}
+
+// hasCommandPermission reports whether the invoking member may run cmd.
+// Members with the Manage Server permission always pass.
+func hasCommandPermission(ctx *CommandContext, cmd *Command) bool {
+	return hasPermission(ctx, cmd.Permission)
+}
+
+// hasPermission reports whether the invoking member has at least level access.
+func hasPermission(ctx *CommandContext, level PermissionLevel) bool {
+	if level == PermissionEveryone {
+		return true
+	}
+	if ctx.Author == nil {
+		return false
+	}
+	if hasManageGuild(ctx) {
+		return true
+	}
+
+	memberRoles := ctx.memberRoles()
+	if len(memberRoles) == 0 {
+		return false
+	}
+
+	allowed, err := fetchPermissionRoles(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Printf("Error fetching permission roles: %v", err)
+		return false
+	}
+
+	for _, roleID := range memberRoles {
+		for roleLevel, roleIDs := range allowed {
+			if roleLevel < level {
+				continue
+			}
+			for _, id := range roleIDs {
+				if id == roleID {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// permissionDenied explains which roles may run cmd.
+func permissionDenied(ctx *CommandContext, cmd *Command) string {
+	allowed, err := fetchPermissionRoles(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Printf("Error fetching permission roles: %v", err)
+	}
+
+	var mentions []string
+	for level := cmd.Permission; level <= PermissionAdmin; level++ {
+		for _, roleID := range allowed[level] {
+			mentions = append(mentions, "<@&"+roleID+">")
+		}
+	}
+
+	msg := fmt.Sprintf("You need %s access to use `%s`.", cmd.Permission, commandPrefix+cmd.Name)
+	if len(mentions) > 0 {
+		msg += " Allowed roles: " + strings.Join(mentions, ", ") + "."
+	} else {
+		msg += " No roles are configured yet, so only members with Manage Server can use it."
+	}
+	return msg
+}
+
+func hasManageGuild(ctx *CommandContext) bool {
+	var perms int64
+	if ctx.Member != nil && ctx.Member.Permissions != 0 {
+		perms = ctx.Member.Permissions // Present on interactions
+	} else {
+		var err error
+		perms, err = ctx.Session.UserChannelPermissions(ctx.Author.ID, ctx.ChannelID)
+		if err != nil {
+			log.Printf("Error checking permissions for %s: %v", ctx.Author.ID, err)
+			return false
+		}
+	}
+	return perms&discordgo.PermissionManageServer != 0 || perms&discordgo.PermissionAdministrator != 0
+}
+
+// memberRoles returns the invoking member's role IDs, fetching the member if
+// the event did not include them.
+func (c *CommandContext) memberRoles() []string {
+	if c.Member != nil && len(c.Member.Roles) > 0 {
+		return c.Member.Roles
+	}
+	member, err := c.Session.State.Member(c.GuildID, c.Author.ID)
+	if err != nil {
+		member, err = c.Session.GuildMember(c.GuildID, c.Author.ID)
+		if err != nil {
+			log.Printf("Error fetching member %s: %v", c.Author.ID, err)
+			return nil
+		}
+	}
+	return member.Roles
+}
+
+// ===================================Permission roles in database===========================================
+func fetchPermissionRoles(db *sql.DB, guildID string) (map[PermissionLevel][]string, error) {
+	rows, err := db.Query(`SELECT level, role_id FROM permission_roles WHERE guild_id = ?`, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching permission roles: %v", err)
+	}
+	defer rows.Close()
+
+	roles := make(map[PermissionLevel][]string)
+	for rows.Next() {
+		var levelName, roleID string
+		if err := rows.Scan(&levelName, &roleID); err != nil {
+			return nil, fmt.Errorf("error reading permission role: %v", err)
+		}
+		if level, ok := parsePermissionLevel(levelName); ok {
+			roles[level] = append(roles[level], roleID)
+		}
+	}
+	return roles, rows.Err()
+}
+
+func addPermissionRole(db *sql.DB, guildID string, level PermissionLevel, roleID string) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO permission_roles (guild_id, level, role_id) VALUES (?, ?, ?)`, guildID, level.String(), roleID)
+	return err
+}
+
+func removePermissionRole(db *sql.DB, guildID string, level PermissionLevel, roleID string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM permission_roles WHERE guild_id = ? AND level = ? AND role_id = ?`, guildID, level.String(), roleID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ===================================Permission role command===========================================
+func handlePermRoleAdd(ctx *CommandContext, levelName string, role *discordgo.Role) {
+	level, ok := parsePermissionLevel(levelName)
+	if !ok {
+		ctx.Reply("Unknown access level. Use `admin`, `teacher` or `ta`.")
+		return
+	}
+	if err := addPermissionRole(guildDB(ctx.GuildID), ctx.GuildID, level, role.ID); err != nil {
+		log.Printf("Error adding permission role: %v", err)
+		ctx.Reply("Failed to save the role.")
+		return
+	}
+	ctx.Reply(fmt.Sprintf("Role '%s' now has %s access.", role.Name, level))
+}
+
+func handlePermRoleRemove(ctx *CommandContext, levelName string, role *discordgo.Role) {
+	level, ok := parsePermissionLevel(levelName)
+	if !ok {
+		ctx.Reply("Unknown access level. Use `admin`, `teacher` or `ta`.")
+		return
+	}
+	removed, err := removePermissionRole(guildDB(ctx.GuildID), ctx.GuildID, level, role.ID)
+	if err != nil {
+		log.Printf("Error removing permission role: %v", err)
+		ctx.Reply("Failed to remove the role.")
+		return
+	}
+	if !removed {
+		ctx.Reply(fmt.Sprintf("Role '%s' did not have %s access.", role.Name, level))
+		return
+	}
+	ctx.Reply(fmt.Sprintf("Role '%s' no longer has %s access.", role.Name, level))
+}
+
+func handlePermRoleList(ctx *CommandContext) {
+	allowed, err := fetchPermissionRoles(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Printf("Error fetching permission roles: %v", err)
+		ctx.Reply("Failed to fetch permission roles.")
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Command Access",
+		Description: "Members with Manage Server always have admin access.",
+		Color:       0x00ff00, // Green color
+	}
+	for level := PermissionAdmin; level > PermissionEveryone; level-- {
+		value := "None"
+		if len(allowed[level]) > 0 {
+			var mentions []string
+			for _, roleID := range allowed[level] {
+				mentions = append(mentions, "<@&"+roleID+">")
+			}
+			value = strings.Join(mentions, ", ")
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   level.String(),
+			Value:  value,
+			Inline: false,
+		})
+	}
+	ctx.ReplyEmbed(embed)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ==================================ATTENDANCE POLICY===========================================
+// AttendancePolicy holds the rules used to turn voice time into a mark. A
+// guild can set a default policy and override it per class.
+type AttendancePolicy struct {
+	// PreWindow is how long before the class start joining already counts.
+	PreWindow time.Duration
+	// LateThreshold is the grace period after the start before a join is late.
+	LateThreshold time.Duration
+	// MinPresencePercent is the share of the class a student must attend to
+	// not be marked absent.
+	MinPresencePercent int
+	// EarlyLeaveThreshold marks students who leave for good more than this
+	// long before the end. Zero disables early-leave marks.
+	EarlyLeaveThreshold time.Duration
+
+	PresentLabel    string
+	LateLabel       string
+	AbsentLabel     string
+	EarlyLeaveLabel string
+}
+
+var defaultAttendancePolicy = AttendancePolicy{
+	PreWindow:           10 * time.Minute,
+	LateThreshold:       10 * time.Minute,
+	MinPresencePercent:  0,
+	EarlyLeaveThreshold: 0,
+	PresentLabel:        "X",
+	LateLabel:           "L",
+	AbsentLabel:         "A",
+	EarlyLeaveLabel:     "E",
+}
+
+// policyFor returns the policy of className, falling back to the guild's
+// default policy and then to defaultAttendancePolicy.
+func policyFor(guildID, className string) AttendancePolicy {
+	db := guildDB(guildID)
+	for _, name := range []string{className, ""} {
+		policy, found, err := fetchAttendancePolicy(db, guildID, name)
+		if err != nil {
+			log.Printf("Error fetching attendance policy: %v", err)
+			break
+		}
+		if found {
+			return policy
+		}
+		if name == "" {
+			break
+		}
+	}
+	return defaultAttendancePolicy
+}
+
+func fetchAttendancePolicy(db *sql.DB, guildID, className string) (AttendancePolicy, bool, error) {
+	var policy AttendancePolicy
+	var preWindow, late, earlyLeave int
+	err := db.QueryRow(`
+		SELECT pre_window_minutes, late_minutes, min_presence_percent, early_leave_minutes,
+			present_label, late_label, absent_label, early_leave_label
+		FROM attendance_policies WHERE guild_id = ? AND class_name = ? COLLATE NOCASE
+	`, guildID, className).Scan(&preWindow, &late, &policy.MinPresencePercent, &earlyLeave,
+		&policy.PresentLabel, &policy.LateLabel, &policy.AbsentLabel, &policy.EarlyLeaveLabel)
+	if err == sql.ErrNoRows {
+		return policy, false, nil
+	}
+	if err != nil {
+		return policy, false, fmt.Errorf("error reading attendance policy: %v", err)
+	}
+	policy.PreWindow = time.Duration(preWindow) * time.Minute
+	policy.LateThreshold = time.Duration(late) * time.Minute
+	policy.EarlyLeaveThreshold = time.Duration(earlyLeave) * time.Minute
+	return policy, true, nil
+}
+
+func saveAttendancePolicy(db *sql.DB, guildID, className string, policy AttendancePolicy) error {
+	_, err := db.Exec(`
+		INSERT INTO attendance_policies (guild_id, class_name, pre_window_minutes, late_minutes, min_presence_percent,
+			early_leave_minutes, present_label, late_label, absent_label, early_leave_label)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(guild_id, class_name) DO UPDATE SET
+			pre_window_minutes = excluded.pre_window_minutes,
+			late_minutes = excluded.late_minutes,
+			min_presence_percent = excluded.min_presence_percent,
+			early_leave_minutes = excluded.early_leave_minutes,
+			present_label = excluded.present_label,
+			late_label = excluded.late_label,
+			absent_label = excluded.absent_label,
+			early_leave_label = excluded.early_leave_label
+	`, guildID, className, int(policy.PreWindow/time.Minute), int(policy.LateThreshold/time.Minute),
+		policy.MinPresencePercent, int(policy.EarlyLeaveThreshold/time.Minute),
+		policy.PresentLabel, policy.LateLabel, policy.AbsentLabel, policy.EarlyLeaveLabel)
+	if err != nil {
+		return fmt.Errorf("error saving attendance policy: %v", err)
+	}
+	return nil
+}
+
+func deleteAttendancePolicy(db *sql.DB, guildID, className string) error {
+	_, err := db.Exec(`DELETE FROM attendance_policies WHERE guild_id = ? AND class_name = ? COLLATE NOCASE`, guildID, className)
+	if err != nil {
+		return fmt.Errorf("error deleting attendance policy: %v", err)
+	}
+	return nil
+}
+
+// applyPolicySettings updates policy from key=value settings.
+func applyPolicySettings(policy *AttendancePolicy, settings map[string]string) error {
+	for key, value := range settings {
+		var err error
+		switch key {
+		case "prewindow":
+			policy.PreWindow, err = parsePolicyDuration(value)
+		case "late":
+			policy.LateThreshold, err = parsePolicyDuration(value)
+		case "earlyleave":
+			policy.EarlyLeaveThreshold, err = parsePolicyDuration(value)
+		case "minpresence":
+			policy.MinPresencePercent, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err == nil && (policy.MinPresencePercent < 0 || policy.MinPresencePercent > 100) {
+				err = fmt.Errorf("must be between 0 and 100")
+			}
+		case "present":
+			policy.PresentLabel = value
+		case "latelabel":
+			policy.LateLabel = value
+		case "absent":
+			policy.AbsentLabel = value
+		case "earlylabel":
+			policy.EarlyLeaveLabel = value
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+	}
+	return nil
+}
+
+func parsePolicyDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("use e.g. 10m")
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return d.Truncate(time.Minute), nil
+}
+
+// ===================================Policy command===========================================
+// handlePolicy shows the policy for className (the guild default when empty),
+// or updates it when settings are given.
+func handlePolicy(ctx *CommandContext, className string, settings map[string]string) {
+	db := guildDB(ctx.GuildID)
+
+	if len(settings) > 0 {
+		policy := policyFor(ctx.GuildID, className)
+		if err := applyPolicySettings(&policy, settings); err != nil {
+			ctx.Reply(err.Error())
+			return
+		}
+		if err := saveAttendancePolicy(db, ctx.GuildID, className, policy); err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to save attendance policy.")
+			return
+		}
+	}
+
+	ctx.ReplyEmbed(policyEmbed(className, policyFor(ctx.GuildID, className)))
+}
+
+func handlePolicyReset(ctx *CommandContext, className string) {
+	if err := deleteAttendancePolicy(guildDB(ctx.GuildID), ctx.GuildID, className); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to reset attendance policy.")
+		return
+	}
+	ctx.ReplyEmbed(policyEmbed(className, policyFor(ctx.GuildID, className)))
+}
+
+func policyEmbed(className string, policy AttendancePolicy) *discordgo.MessageEmbed {
+	title := "Attendance Policy"
+	if className != "" {
+		title += ": " + className
+	}
+	earlyLeave := "off"
+	if policy.EarlyLeaveThreshold > 0 {
+		earlyLeave = fmt.Sprintf("leaving %s before the end is marked `%s`", policy.EarlyLeaveThreshold, policy.EarlyLeaveLabel)
+	}
+	return &discordgo.MessageEmbed{
+		Title: title,
+		Color: 0x00ff00, // Green color
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Pre-class window", Value: policy.PreWindow.String(), Inline: true},
+			{Name: "Late after", Value: policy.LateThreshold.String(), Inline: true},
+			{Name: "Minimum presence", Value: fmt.Sprintf("%d%%", policy.MinPresencePercent), Inline: true},
+			{Name: "Early leave", Value: earlyLeave, Inline: false},
+			{Name: "Labels", Value: fmt.Sprintf("present `%s`, late `%s`, absent `%s`, early leave `%s`",
+				policy.PresentLabel, policy.LateLabel, policy.AbsentLabel, policy.EarlyLeaveLabel), Inline: false},
+		},
+	}
+}
@@ -1,12 +1,59 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const defaultReactionEmoji = "✅"
+
+// Reaction role menu modes.
+const (
+	MenuModeNormal    = "normal"    // Reacting adds the role, un-reacting removes it
+	MenuModeExclusive = "exclusive" // Picking one role removes the menu's other roles
+	MenuModeVerify    = "verify"    // Reacting adds the role, un-reacting keeps it
+)
+
+// ReactionMenu holds the settings shared by every binding on one message.
+type ReactionMenu struct {
+	MessageID string
+	GuildID   string
+	ChannelID string
+	Mode      string
+	MaxRoles  int // 0 means no limit
+}
+
+// MenuEntry is one emoji → role pair of a menu being created.
+type MenuEntry struct {
+	Emoji    string
+	RoleName string
+	RoleID   string // Looked up from RoleName when empty
+}
+
+// resolveRole returns the role ID of the entry.
+func (e MenuEntry) resolveRole(s *discordgo.Session, guildID string) (string, error) {
+	if e.RoleID != "" {
+		return e.RoleID, nil
+	}
+	return findRoleByNameReac(s, guildID, e.RoleName)
+}
+
+// ReactionRole binds an emoji on a message to a role.
+type ReactionRole struct {
+	ID        int64
+	GuildID   string
+	ChannelID string
+	MessageID string
+	Emoji     string // Unicode emoji, or "name:id" for custom emojis
+	RoleID    string
+}
+
 // =========================================Reaction Role========================================================
 func findRoleByNameReac(s *discordgo.Session, guildID, roleName string) (string, error) {
 	roles, err := s.GuildRoles(guildID)
@@ -22,8 +69,378 @@ func findRoleByNameReac(s *discordgo.Session, guildID, roleName string) (string,
 	return "", fmt.Errorf("role %s not found", roleName)
 }
 
+// handleReactionRoleCreate posts message and assigns roleID to everyone who
+// reacts to it with ✅.
+func handleReactionRoleCreate(ctx *CommandContext, roleID, message string) {
+	s := ctx.Session
+
+	msg, err := s.ChannelMessageSend(ctx.ChannelID, message)
+	if err != nil {
+		ctx.Reply("Failed to send message: " + err.Error())
+		return
+	}
+
+	err = s.MessageReactionAdd(msg.ChannelID, msg.ID, defaultReactionEmoji)
+	if err != nil {
+		ctx.Reply("Failed to add reaction: " + err.Error())
+		return
+	}
+
+	binding := &ReactionRole{
+		GuildID:   ctx.GuildID,
+		ChannelID: msg.ChannelID,
+		MessageID: msg.ID,
+		Emoji:     defaultReactionEmoji,
+		RoleID:    roleID,
+	}
+	if err := saveReactionRole(guildDB(ctx.GuildID), binding); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save reaction role: " + err.Error())
+		return
+	}
+
+	if ctx.Interaction != nil {
+		ctx.Reply("Reaction role message created.")
+	}
+}
+
+// parseMenuEntries reads one "emoji role name" pair per line.
+func parseMenuEntries(lines []string) ([]MenuEntry, error) {
+	var entries []MenuEntry
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		emoji, roleName, ok := strings.Cut(line, " ")
+		roleName = strings.TrimSpace(roleName)
+		if !ok || roleName == "" {
+			return nil, fmt.Errorf("line %q needs an emoji followed by a role name", line)
+		}
+		entries = append(entries, MenuEntry{Emoji: normalizeEmoji(emoji), RoleName: roleName})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("add at least one emoji and role")
+	}
+	return entries, nil
+}
+
+// parseReactionMenuArgs reads the prefix form of `!reacmenu`: the first line
+// holds an optional mode, an optional max=N and the message text, every
+// following line one "emoji role name" pair.
+func parseReactionMenuArgs(raw string) (mode string, maxRoles int, message string, entries []MenuEntry, err error) {
+	lines := strings.Split(raw, "\n")
+	words := strings.Fields(lines[0])
+	if len(words) > 0 {
+		switch strings.ToLower(words[0]) {
+		case MenuModeNormal, MenuModeExclusive, MenuModeVerify:
+			mode = strings.ToLower(words[0])
+			words = words[1:]
+		}
+	}
+	if len(words) > 0 && strings.HasPrefix(strings.ToLower(words[0]), "max=") {
+		maxRoles, err = strconv.Atoi(words[0][len("max="):])
+		if err != nil || maxRoles < 0 {
+			return "", 0, "", nil, fmt.Errorf("invalid %s, use e.g. max=2", words[0])
+		}
+		words = words[1:]
+	}
+	message = strings.Join(words, " ")
+	entries, err = parseMenuEntries(lines[1:])
+	return mode, maxRoles, message, entries, err
+}
+
+// handleReactionMenuCreate posts message with one reaction per entry and
+// stores the menu. mode is one of the MenuMode constants.
+func handleReactionMenuCreate(ctx *CommandContext, mode string, maxRoles int, message string, entries []MenuEntry) {
+	s := ctx.Session
+	if mode == "" {
+		mode = MenuModeNormal
+	}
+	if mode != MenuModeNormal && mode != MenuModeExclusive && mode != MenuModeVerify {
+		ctx.Reply("Unknown menu mode. Use `normal`, `exclusive` or `verify`.")
+		return
+	}
+
+	if maxRoles < 0 {
+		ctx.Reply("The role limit must not be negative.")
+		return
+	}
+
+	roleIDs := make([]string, len(entries))
+	for i, entry := range entries {
+		roleID, err := entry.resolveRole(s, ctx.GuildID)
+		if err != nil {
+			ctx.Reply(fmt.Sprintf("Role '%s' not found: %v", entry.RoleName, err))
+			return
+		}
+		roleIDs[i] = roleID
+	}
+
+	if message == "" {
+		message = "React below to pick your role."
+	}
+	msg, err := s.ChannelMessageSend(ctx.ChannelID, message)
+	if err != nil {
+		ctx.Reply("Failed to send message: " + err.Error())
+		return
+	}
+
+	db := guildDB(ctx.GuildID)
+	menu := &ReactionMenu{
+		MessageID: msg.ID,
+		GuildID:   ctx.GuildID,
+		ChannelID: msg.ChannelID,
+		Mode:      mode,
+		MaxRoles:  maxRoles,
+	}
+	if err := saveReactionMenu(db, menu); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save reaction role menu.")
+		return
+	}
+
+	for i, entry := range entries {
+		if err := s.MessageReactionAdd(msg.ChannelID, msg.ID, entry.Emoji); err != nil {
+			ctx.Reply(fmt.Sprintf("Failed to add reaction %s: %v", displayEmoji(entry.Emoji), err))
+			continue
+		}
+		binding := &ReactionRole{
+			GuildID:   ctx.GuildID,
+			ChannelID: msg.ChannelID,
+			MessageID: msg.ID,
+			Emoji:     entry.Emoji,
+			RoleID:    roleIDs[i],
+		}
+		if err := saveReactionRole(db, binding); err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to save reaction role: " + err.Error())
+			return
+		}
+	}
+
+	if ctx.Interaction != nil {
+		ctx.Reply("Reaction role menu created.")
+	}
+}
+
+// handleReactionMenuAdd adds another emoji → role pair to an existing menu.
+func handleReactionMenuAdd(ctx *CommandContext, messageID string, entry MenuEntry) {
+	db := guildDB(ctx.GuildID)
+	bindings, err := fetchReactionRoles(db, ctx.GuildID, messageID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch reaction roles.")
+		return
+	}
+	if len(bindings) == 0 {
+		ctx.Reply("No reaction role menu found for that message.")
+		return
+	}
+
+	roleID, err := entry.resolveRole(ctx.Session, ctx.GuildID)
+	if err != nil {
+		ctx.Reply(fmt.Sprintf("Role '%s' not found: %v", entry.RoleName, err))
+		return
+	}
+
+	channelID := bindings[0].ChannelID
+	if err := ctx.Session.MessageReactionAdd(channelID, messageID, entry.Emoji); err != nil {
+		ctx.Reply(fmt.Sprintf("Failed to add reaction %s: %v", displayEmoji(entry.Emoji), err))
+		return
+	}
+	binding := &ReactionRole{
+		GuildID:   ctx.GuildID,
+		ChannelID: channelID,
+		MessageID: messageID,
+		Emoji:     entry.Emoji,
+		RoleID:    roleID,
+	}
+	if err := saveReactionRole(db, binding); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save reaction role: " + err.Error())
+		return
+	}
+	ctx.Reply(fmt.Sprintf("%s now gives <@&%s>.", displayEmoji(entry.Emoji), roleID))
+}
+
+func handleReactionRoleList(ctx *CommandContext) {
+	bindings, err := fetchReactionRoles(guildDB(ctx.GuildID), ctx.GuildID, "")
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch reaction roles.")
+		return
+	}
+	if len(bindings) == 0 {
+		ctx.Reply("No reaction roles are set up.")
+		return
+	}
+
+	var lines []string
+	lastMessageID := ""
+	for _, b := range bindings {
+		if b.MessageID != lastMessageID {
+			lastMessageID = b.MessageID
+			menu := fetchReactionMenu(guildDB(ctx.GuildID), b.GuildID, b.ChannelID, b.MessageID)
+			link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", b.GuildID, b.ChannelID, b.MessageID)
+			header := fmt.Sprintf("**[%s](%s)** %s", b.MessageID, link, menu.Mode)
+			if menu.MaxRoles > 0 {
+				header += fmt.Sprintf(", max %d", menu.MaxRoles)
+			}
+			lines = append(lines, header)
+		}
+		lines = append(lines, fmt.Sprintf("%s → <@&%s>", displayEmoji(b.Emoji), b.RoleID))
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Reaction Roles",
+		Description: strings.Join(lines, "\n"),
+		Color:       0x00ff00, // Green color
+	}
+	ctx.ReplyEmbed(embed)
+}
+
+// handleReactionRoleDelete removes the bindings of messageID, or only the one
+// for emoji when it is given, and takes the bot's reaction off the message.
+func handleReactionRoleDelete(ctx *CommandContext, messageID, emoji string) {
+	db := guildDB(ctx.GuildID)
+	bindings, err := fetchReactionRoles(db, ctx.GuildID, messageID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch reaction roles.")
+		return
+	}
+
+	emoji = normalizeEmoji(emoji)
+	removed := 0
+	for _, b := range bindings {
+		if emoji != "" && b.Emoji != emoji {
+			continue
+		}
+		if err := deleteReactionRole(db, b.ID); err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to delete reaction role.")
+			return
+		}
+		ctx.Session.MessageReactionRemove(b.ChannelID, b.MessageID, b.Emoji, "@me")
+		removed++
+	}
+
+	if removed == 0 {
+		ctx.Reply("No reaction role found for that message.")
+		return
+	}
+	if removed == len(bindings) {
+		if err := deleteReactionMenu(db, messageID); err != nil {
+			log.Println(err)
+		}
+	}
+	ctx.Reply(fmt.Sprintf("Deleted %d reaction role binding(s).", removed))
+}
+
+// ===================================Reaction events===========================================
+// reactionAdd and reactionRemove are registered once and look up the
+// bindings of the reacted message in the database.
+func reactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.GuildID == "" || r.UserID == s.State.User.ID {
+		return
+	}
+	binding, bindings, ok := lookupReactionRole(r.GuildID, r.MessageID, r.Emoji)
+	if !ok {
+		return
+	}
+
+	menu := fetchReactionMenu(guildDB(r.GuildID), r.GuildID, r.ChannelID, r.MessageID)
+	if !enforceMenuLimits(s, r, menu, binding, bindings) {
+		return
+	}
+	handleReactionAdd(s, r, binding.MessageID, binding.RoleID)
+}
+
+func reactionRemove(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
+	if r.GuildID == "" || r.UserID == s.State.User.ID {
+		return
+	}
+	binding, _, ok := lookupReactionRole(r.GuildID, r.MessageID, r.Emoji)
+	if !ok {
+		return
+	}
+
+	menu := fetchReactionMenu(guildDB(r.GuildID), r.GuildID, r.ChannelID, r.MessageID)
+	if menu.Mode == MenuModeVerify {
+		return // Verify-only menus never take a role away
+	}
+	handleReactionRemove(s, r, binding.MessageID, binding.RoleID)
+}
+
+// enforceMenuLimits applies exclusive mode and the per-user role limit before
+// the role of binding is given. It returns false if the role must not be added.
+func enforceMenuLimits(s *discordgo.Session, r *discordgo.MessageReactionAdd, menu *ReactionMenu, binding *ReactionRole, bindings []*ReactionRole) bool {
+	if menu.Mode != MenuModeExclusive && menu.MaxRoles == 0 {
+		return true
+	}
+
+	memberRoles := make(map[string]bool)
+	if r.Member != nil {
+		for _, roleID := range r.Member.Roles {
+			memberRoles[roleID] = true
+		}
+	} else if member, err := s.GuildMember(r.GuildID, r.UserID); err == nil {
+		for _, roleID := range member.Roles {
+			memberRoles[roleID] = true
+		}
+	}
+
+	if menu.Mode == MenuModeExclusive {
+		for _, other := range bindings {
+			// Only the other picks the member holds need undoing; each
+			// removal is a rate-limited REST call.
+			if other.Emoji == binding.Emoji || other.RoleID == binding.RoleID || !memberRoles[other.RoleID] {
+				continue
+			}
+			// Removing the reaction fires reactionRemove, which drops the role.
+			if err := s.MessageReactionRemove(r.ChannelID, r.MessageID, other.Emoji, r.UserID); err != nil {
+				log.Printf("Failed to undo exclusive pick %s for %s: %v", other.Emoji, r.UserID, err)
+			}
+		}
+		return true
+	}
+
+	held := 0
+	for _, other := range bindings {
+		if other.RoleID != binding.RoleID && memberRoles[other.RoleID] {
+			held++
+		}
+	}
+	if held >= menu.MaxRoles {
+		s.MessageReactionRemove(r.ChannelID, r.MessageID, emojiKey(r.Emoji), r.UserID)
+		if channel, err := s.UserChannelCreate(r.UserID); err == nil {
+			s.ChannelMessageSend(channel.ID, fmt.Sprintf("You can pick at most %d role(s) from that menu. Remove a reaction first.", menu.MaxRoles))
+		}
+		return false
+	}
+	return true
+}
+
+// lookupReactionRole returns the binding for emoji on messageID together with
+// every binding of that message.
+func lookupReactionRole(guildID, messageID string, emoji discordgo.Emoji) (*ReactionRole, []*ReactionRole, bool) {
+	bindings, err := fetchReactionRoles(guildDB(guildID), guildID, messageID)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, false
+	}
+	key := emojiKey(emoji)
+	for _, b := range bindings {
+		if b.Emoji == key {
+			return b, bindings, true
+		}
+	}
+	return nil, nil, false
+}
+
 func handleReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd, messageID string, roleID string) {
-	if r.MessageID != messageID || r.Emoji.Name != "✅" {
+	if r.MessageID != messageID {
 		return
 	}
 
@@ -35,7 +452,7 @@ func handleReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd, me
 }
 
 func handleReactionRemove(s *discordgo.Session, r *discordgo.MessageReactionRemove, messageID string, roleID string) {
-	if r.MessageID != messageID || r.Emoji.Name != "✅" {
+	if r.MessageID != messageID {
 		return
 	}
 
@@ -45,3 +462,112 @@ func handleReactionRemove(s *discordgo.Session, r *discordgo.MessageReactionRemo
 		return
 	}
 }
+
+// ===================================Emoji keys===========================================
+// emojiKey returns the stored form of a reaction emoji: the character itself
+// for Unicode emojis and "name:id" for custom ones.
+func emojiKey(e discordgo.Emoji) string {
+	if e.ID != "" {
+		return e.Name + ":" + e.ID
+	}
+	return e.Name
+}
+
+// normalizeEmoji turns user input such as "<:name:id>" or "<a:name:id>" into
+// an emoji key.
+func normalizeEmoji(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "<") && strings.HasSuffix(s, ">") {
+		s = strings.TrimPrefix(strings.Trim(s, "<>"), "a:")
+		s = strings.TrimPrefix(s, ":")
+	}
+	return s
+}
+
+// displayEmoji renders an emoji key in a message.
+func displayEmoji(key string) string {
+	if strings.Contains(key, ":") {
+		return "<:" + key + ">"
+	}
+	return key
+}
+
+// ===================================Reaction role database===========================================
+func fetchReactionRoles(db *sql.DB, guildID, messageID string) ([]*ReactionRole, error) {
+	query := `SELECT id, guild_id, channel_id, message_id, emoji, role_id FROM reaction_roles WHERE guild_id = ?`
+	args := []interface{}{guildID}
+	if messageID != "" {
+		query += ` AND message_id = ?`
+		args = append(args, messageID)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching reaction roles: %v", err)
+	}
+	defer rows.Close()
+
+	var bindings []*ReactionRole
+	for rows.Next() {
+		var b ReactionRole
+		if err := rows.Scan(&b.ID, &b.GuildID, &b.ChannelID, &b.MessageID, &b.Emoji, &b.RoleID); err != nil {
+			return nil, fmt.Errorf("error reading reaction role: %v", err)
+		}
+		bindings = append(bindings, &b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through reaction roles: %v", err)
+	}
+	return bindings, nil
+}
+
+func saveReactionRole(db *sql.DB, b *ReactionRole) error {
+	res, err := db.Exec(`
+		INSERT INTO reaction_roles (guild_id, channel_id, message_id, emoji, role_id) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(message_id, emoji) DO UPDATE SET role_id = excluded.role_id
+	`, b.GuildID, b.ChannelID, b.MessageID, b.Emoji, b.RoleID)
+	if err != nil {
+		return fmt.Errorf("error saving reaction role: %v", err)
+	}
+	b.ID, _ = res.LastInsertId()
+	return nil
+}
+
+func deleteReactionRole(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM reaction_roles WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting reaction role: %v", err)
+	}
+	return nil
+}
+
+// fetchReactionMenu returns the menu settings of a message. Messages created
+// before menus existed, or by `!reacrole`, behave as normal menus.
+func fetchReactionMenu(db *sql.DB, guildID, channelID, messageID string) *ReactionMenu {
+	menu := &ReactionMenu{MessageID: messageID, GuildID: guildID, ChannelID: channelID, Mode: MenuModeNormal}
+	err := db.QueryRow(`SELECT mode, max_roles FROM reaction_role_menus WHERE message_id = ?`, messageID).Scan(&menu.Mode, &menu.MaxRoles)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error fetching reaction role menu: %v", err)
+	}
+	return menu
+}
+
+func saveReactionMenu(db *sql.DB, menu *ReactionMenu) error {
+	_, err := db.Exec(`
+		INSERT INTO reaction_role_menus (message_id, guild_id, channel_id, mode, max_roles) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(message_id) DO UPDATE SET mode = excluded.mode, max_roles = excluded.max_roles
+	`, menu.MessageID, menu.GuildID, menu.ChannelID, menu.Mode, menu.MaxRoles)
+	if err != nil {
+		return fmt.Errorf("error saving reaction role menu: %v", err)
+	}
+	return nil
+}
+
+func deleteReactionMenu(db *sql.DB, messageID string) error {
+	_, err := db.Exec(`DELETE FROM reaction_role_menus WHERE message_id = ?`, messageID)
+	if err != nil {
+		return fmt.Errorf("error deleting reaction role menu: %v", err)
+	}
+	return nil
+}
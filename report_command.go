@@ -0,0 +1,369 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	reportDateLayout   = "2006-01-02"
+	reportLinesPerPage = 20 // Lines per embed of a report
+)
+
+// ==================================ATTENDANCE REPORTS===========================================
+// ReportTotals counts the attendance results of a report.
+type ReportTotals struct {
+	Present    int
+	Late       int
+	EarlyLeave int
+	Absent     int
+
+	percentSum int
+	lateSum    time.Duration
+}
+
+func (t *ReportTotals) Add(result AttendanceResult) {
+	switch result.Status {
+	case attendanceLate:
+		t.Late++
+		t.lateSum += result.Late
+	case attendanceEarlyLeave:
+		t.EarlyLeave++
+	case attendanceAbsent:
+		t.Absent++
+	default:
+		t.Present++
+	}
+	t.percentSum += result.Percent
+}
+
+// Sessions is the number of results counted.
+func (t ReportTotals) Sessions() int {
+	return t.Present + t.Late + t.EarlyLeave + t.Absent
+}
+
+// AttendanceRate is the percentage of sessions that were not missed.
+func (t ReportTotals) AttendanceRate() int {
+	if t.Sessions() == 0 {
+		return 0
+	}
+	return (t.Sessions() - t.Absent) * 100 / t.Sessions()
+}
+
+// AverageLate is the average lateness of the late arrivals.
+func (t ReportTotals) AverageLate() time.Duration {
+	if t.Late == 0 {
+		return 0
+	}
+	return t.lateSum / time.Duration(t.Late)
+}
+
+// Fields shows the totals as embed fields.
+func (t ReportTotals) Fields() []*discordgo.MessageEmbedField {
+	averageTime := 0
+	if t.Sessions() > 0 {
+		averageTime = t.percentSum / t.Sessions()
+	}
+	averageLate := "-"
+	if t.Late > 0 {
+		averageLate = formatLateness(t.AverageLate())
+	}
+	return []*discordgo.MessageEmbedField{
+		{Name: "Present", Value: fmt.Sprint(t.Present), Inline: true},
+		{Name: "Late", Value: fmt.Sprint(t.Late), Inline: true},
+		{Name: "Left early", Value: fmt.Sprint(t.EarlyLeave), Inline: true},
+		{Name: "Absent", Value: fmt.Sprint(t.Absent), Inline: true},
+		{Name: "Attendance", Value: fmt.Sprintf("%d%%", t.AttendanceRate()), Inline: true},
+		{Name: "Average time in class", Value: fmt.Sprintf("%d%%", averageTime), Inline: true},
+		{Name: "Average lateness", Value: averageLate, Inline: true},
+	}
+}
+
+// Summary is a one-line version of the totals.
+func (t ReportTotals) Summary() string {
+	summary := fmt.Sprintf("%d present, %d late, %d absent", t.Present, t.Late, t.Absent)
+	if t.EarlyLeave > 0 {
+		summary += fmt.Sprintf(", %d left early", t.EarlyLeave)
+	}
+	return summary + fmt.Sprintf(" (%d%%)", t.AttendanceRate())
+}
+
+// describeResult shows a result as e.g. "Late 5m12s, 80%".
+func describeResult(result AttendanceResult) string {
+	switch result.Status {
+	case attendanceLate:
+		return fmt.Sprintf("Late %s, %d%%", formatLateness(result.Late), result.Percent)
+	case attendanceEarlyLeave:
+		return fmt.Sprintf("Left early, %d%%", result.Percent)
+	case attendanceAbsent:
+		return fmt.Sprintf("Absent, %d%%", result.Percent)
+	default:
+		return fmt.Sprintf("Present, %d%%", result.Percent)
+	}
+}
+
+func formatLateness(d time.Duration) string {
+	return fmt.Sprintf("%dm%ds", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// splitReportDates takes up to two trailing YYYY-MM-DD dates off args and
+// returns the remaining words with the from and to dates.
+func splitReportDates(args []string) (words []string, from, to string, err error) {
+	var dates []string
+	for len(args) > 0 && len(dates) < 2 {
+		last := args[len(args)-1]
+		if _, err := time.Parse(reportDateLayout, last); err != nil {
+			break
+		}
+		dates = append([]string{last}, dates...)
+		args = args[:len(args)-1]
+	}
+	switch len(dates) {
+	case 2:
+		from, to = dates[0], dates[1]
+	case 1:
+		from = dates[0]
+	}
+	return args, from, to, checkReportRange(from, to)
+}
+
+// checkReportRange validates dates given to a report.
+func checkReportRange(from, to string) error {
+	for _, date := range []string{from, to} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(reportDateLayout, date); err != nil {
+			return fmt.Errorf("invalid date %q, use YYYY-MM-DD", date)
+		}
+	}
+	if from != "" && to != "" && from > to {
+		return fmt.Errorf("the start date %s is after the end date %s", from, to)
+	}
+	return nil
+}
+
+// reportRangeText describes a date range for a report title.
+func reportRangeText(from, to string) string {
+	switch {
+	case from != "" && to != "":
+		return fmt.Sprintf(" from %s to %s", from, to)
+	case from != "":
+		return " since " + from
+	case to != "":
+		return " until " + to
+	}
+	return ""
+}
+
+// sessionResults evaluates userIDs in every session, using the policy of each
+// session's class.
+type sessionResults struct {
+	db       *sql.DB
+	policies map[string]AttendancePolicy
+}
+
+func newSessionResults(db *sql.DB) *sessionResults {
+	return &sessionResults{db: db, policies: make(map[string]AttendancePolicy)}
+}
+
+func (r *sessionResults) evaluate(userID string, session ClassSession) (AttendanceResult, error) {
+	policy, ok := r.policies[session.ClassName]
+	if !ok {
+		policy = policyFor(session.GuildID, session.ClassName)
+		r.policies[session.ClassName] = policy
+	}
+	return evaluateAttendance(r.db, userID, session, policy)
+}
+
+// replyReport sends a report as embeds of reportLinesPerPage lines, with the
+// totals on the first page.
+func replyReport(ctx *CommandContext, title string, totals ReportTotals, lines []string) {
+	embeds := pagedEmbeds(title, lines, reportLinesPerPage)
+	embeds[0].Fields = totals.Fields()
+	for _, embed := range embeds {
+		ctx.ReplyEmbed(embed)
+	}
+}
+
+// handleReportStudent reports the attendance of the student ref, or of the
+// caller when ref is empty, in the sessions between from and to they were
+// enrolled for. Only TAs and above may look at other students.
+func handleReportStudent(ctx *CommandContext, ref, from, to string) {
+	db := guildDB(ctx.GuildID)
+	ref = strings.TrimSpace(ref)
+	userID := mentionedUserID(ref)
+	switch {
+	case ref == "" && ctx.Author != nil:
+		userID = ctx.Author.ID
+	case userID == "":
+		student, err := findStudent(db, ctx.GuildID, ref)
+		if err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to fetch student data.")
+			return
+		}
+		if student == nil {
+			ctx.Reply(fmt.Sprintf("No student named %s.", ref))
+			return
+		}
+		userID = student.UserID
+	}
+	if (ctx.Author == nil || userID != ctx.Author.ID) && !hasPermission(ctx, PermissionTA) {
+		ctx.Reply("You can only see your own attendance.")
+		return
+	}
+
+	name := userID
+	if student, err := findStudent(db, ctx.GuildID, userID); err != nil {
+		log.Println(err)
+	} else if student != nil {
+		name = student.Name()
+	} else if user, err := ctx.Session.User(userID); err == nil {
+		name = user.Username
+	}
+
+	ctx.Defer()
+	sessions, err := fetchClassSessions(db, ctx.GuildID, "", from, to)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch class sessions.")
+		return
+	}
+	if len(sessions) == 0 {
+		ctx.Reply("No classes were recorded" + reportRangeText(from, to) + ".")
+		return
+	}
+	enrollments, err := fetchEnrollments(db, ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+
+	results := newSessionResults(db)
+	var totals ReportTotals
+	var lines []string
+	for _, session := range sessions {
+		if !enrollments.EnrolledAt(userID, session.ScheduledStart) {
+			continue
+		}
+		result, err := results.evaluate(userID, session)
+		if err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to read attendance data.")
+			return
+		}
+		totals.Add(result)
+		lines = append(lines, fmt.Sprintf("`%s` %s: %s", session.Date, session.ClassName, describeResult(result)))
+	}
+	if len(lines) == 0 {
+		ctx.Reply(fmt.Sprintf("%s was not enrolled in any recorded class%s.", name, reportRangeText(from, to)))
+		return
+	}
+	replyReport(ctx, fmt.Sprintf("Attendance of %s%s", name, reportRangeText(from, to)), totals, lines)
+}
+
+// handleReportClass reports the attendance in className between from and to
+// of every student, counting only the sessions they were enrolled for.
+func handleReportClass(ctx *CommandContext, className, from, to string) {
+	db := guildDB(ctx.GuildID)
+	ctx.Defer()
+	sessions, err := fetchClassSessions(db, ctx.GuildID, className, from, to)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch class sessions.")
+		return
+	}
+	if len(sessions) == 0 {
+		ctx.Reply(fmt.Sprintf("No sessions of %s were recorded%s.", className, reportRangeText(from, to)))
+		return
+	}
+	enrollments, err := fetchEnrollments(db, ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+	students := enrollments.StudentsIn(sessions)
+	if len(students) == 0 {
+		ctx.Reply("No students were enrolled in these sessions.")
+		return
+	}
+
+	results := newSessionResults(db)
+	var totals ReportTotals
+	var lines []string
+	for _, student := range students {
+		var studentTotals ReportTotals
+		for _, session := range sessions {
+			if !enrollments.EnrolledAt(student.UserID, session.ScheduledStart) {
+				continue
+			}
+			result, err := results.evaluate(student.UserID, session)
+			if err != nil {
+				log.Println(err)
+				ctx.Reply("Failed to read attendance data.")
+				return
+			}
+			studentTotals.Add(result)
+			totals.Add(result)
+		}
+		line := fmt.Sprintf("<@%s> %s", student.UserID, studentTotals.Summary())
+		if studentTotals.Late > 0 {
+			line += fmt.Sprintf(", late by %s on average", formatLateness(studentTotals.AverageLate()))
+		}
+		lines = append(lines, line)
+	}
+
+	title := fmt.Sprintf("%s: %d sessions%s", sessions[0].ClassName, len(sessions), reportRangeText(from, to))
+	replyReport(ctx, title, totals, lines)
+}
+
+// handleReportToday reports the students enrolled in each class held today.
+func handleReportToday(ctx *CommandContext) {
+	db := guildDB(ctx.GuildID)
+	loc := guildLocation(ctx.GuildID)
+	today := time.Now().In(loc).Format(reportDateLayout)
+
+	ctx.Defer()
+	sessions, err := fetchClassSessions(db, ctx.GuildID, "", today, today)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch class sessions.")
+		return
+	}
+	if len(sessions) == 0 {
+		ctx.Reply("No classes have been held today.")
+		return
+	}
+	enrollments, err := fetchEnrollments(db, ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+
+	results := newSessionResults(db)
+	var totals ReportTotals
+	var lines []string
+	for _, session := range sessions {
+		lines = append(lines, fmt.Sprintf("**%s** %s", session.ClassName, session.ScheduledStart.In(loc).Format("15:04")))
+		for _, student := range enrollments.StudentsAt(session.ScheduledStart) {
+			result, err := results.evaluate(student.UserID, session)
+			if err != nil {
+				log.Println(err)
+				ctx.Reply("Failed to read attendance data.")
+				return
+			}
+			totals.Add(result)
+			lines = append(lines, fmt.Sprintf("<@%s>: %s", student.UserID, describeResult(result)))
+		}
+	}
+	replyReport(ctx, "Attendance on "+today, totals, lines)
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Role panel styles.
+const (
+	PanelStyleButtons = "buttons" // One button per role that toggles it
+	PanelStyleSelect  = "select"  // One select menu to pick several roles at once
+)
+
+const (
+	rolePanelToggleID = "rolepanel:toggle:" // Followed by the role ID
+	rolePanelSelectID = "rolepanel:select"
+
+	// Discord allows 5 rows of 5 buttons, and 25 options per select menu.
+	maxPanelRoles = 25
+)
+
+// RolePanel is a message with buttons or a select menu that assign roles.
+type RolePanel struct {
+	MessageID string
+	GuildID   string
+	ChannelID string
+	Style     string
+	Title     string
+	MaxRoles  int // Select menus only; 0 means every role may be picked
+	Roles     []*PanelRole
+}
+
+// PanelRole is one role offered by a panel.
+type PanelRole struct {
+	RoleID string
+	Label  string
+	Emoji  string // Emoji key, may be empty
+}
+
+// ==================================ROLE PANELS===========================================
+// parsePanelRoles reads one role per line, optionally prefixed by an emoji:
+// "Role Name" or "🎮 Role Name".
+func parsePanelRoles(s *discordgo.Session, guildID string, lines []string) ([]*PanelRole, error) {
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*discordgo.Role)
+	for _, role := range roles {
+		byName[role.Name] = role
+	}
+
+	var panelRoles []*PanelRole
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		emoji := ""
+		role, ok := byName[line]
+		if !ok {
+			var name string
+			emoji, name, _ = strings.Cut(line, " ")
+			if role, ok = byName[strings.TrimSpace(name)]; !ok {
+				return nil, fmt.Errorf("role '%s' not found", line)
+			}
+			emoji = normalizeEmoji(emoji)
+		}
+		panelRoles = append(panelRoles, &PanelRole{RoleID: role.ID, Label: role.Name, Emoji: emoji})
+	}
+	if len(panelRoles) == 0 {
+		return nil, fmt.Errorf("add at least one role")
+	}
+	if len(panelRoles) > maxPanelRoles {
+		return nil, fmt.Errorf("a panel can hold at most %d roles", maxPanelRoles)
+	}
+	return panelRoles, nil
+}
+
+func handleRolePanelCreate(ctx *CommandContext, style, title string, maxRoles int, roles []*PanelRole) {
+	if style != PanelStyleButtons && style != PanelStyleSelect {
+		ctx.Reply("Unknown panel style. Use `buttons` or `select`.")
+		return
+	}
+	if title == "" {
+		title = "Pick your roles below."
+	}
+
+	panel := &RolePanel{GuildID: ctx.GuildID, ChannelID: ctx.ChannelID, Style: style, Title: title, MaxRoles: maxRoles, Roles: roles}
+	msg, err := ctx.Session.ChannelMessageSendComplex(ctx.ChannelID, &discordgo.MessageSend{
+		Content:    panel.Title,
+		Components: panel.components(),
+	})
+	if err != nil {
+		ctx.Reply("Failed to send role panel: " + err.Error())
+		return
+	}
+	panel.MessageID = msg.ID
+
+	if err := saveRolePanel(guildDB(ctx.GuildID), panel); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save role panel.")
+		return
+	}
+
+	if ctx.Interaction != nil {
+		ctx.Reply("Role panel created.")
+	}
+}
+
+// handleRolePanelEdit changes a panel with edit and redraws its message.
+func handleRolePanelEdit(ctx *CommandContext, messageID string, edit func(panel *RolePanel) error) {
+	db := guildDB(ctx.GuildID)
+	panel, err := fetchRolePanel(db, ctx.GuildID, messageID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch role panel.")
+		return
+	}
+	if panel == nil {
+		ctx.Reply("No role panel found for that message.")
+		return
+	}
+
+	if err := edit(panel); err != nil {
+		ctx.Reply(err.Error())
+		return
+	}
+
+	components := panel.components()
+	_, err = ctx.Session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         panel.MessageID,
+		Channel:    panel.ChannelID,
+		Content:    &panel.Title,
+		Components: components,
+	})
+	if err != nil {
+		ctx.Reply("Failed to update role panel: " + err.Error())
+		return
+	}
+	if err := saveRolePanel(db, panel); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save role panel.")
+		return
+	}
+	ctx.Reply("Role panel updated.")
+}
+
+// addPanelRoles adds roles to panel, replacing the label and emoji of roles
+// that are already on it.
+func addPanelRoles(panel *RolePanel, roles []*PanelRole) error {
+	for _, role := range roles {
+		if existing := panel.role(role.RoleID); existing != nil {
+			existing.Label, existing.Emoji = role.Label, role.Emoji
+			continue
+		}
+		if len(panel.Roles) >= maxPanelRoles {
+			return fmt.Errorf("a panel can hold at most %d roles", maxPanelRoles)
+		}
+		panel.Roles = append(panel.Roles, role)
+	}
+	return nil
+}
+
+func removePanelRole(panel *RolePanel, roleID string) error {
+	for i, role := range panel.Roles {
+		if role.RoleID == roleID {
+			if len(panel.Roles) == 1 {
+				return fmt.Errorf("a panel needs at least one role, delete the panel instead")
+			}
+			panel.Roles = append(panel.Roles[:i], panel.Roles[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("that role is not on the panel")
+}
+
+func handleRolePanelList(ctx *CommandContext) {
+	panels, err := fetchRolePanels(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch role panels.")
+		return
+	}
+	if len(panels) == 0 {
+		ctx.Reply("No role panels are set up.")
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: "Role Panels",
+		Color: 0x00ff00, // Green color
+	}
+	for _, panel := range panels {
+		link := fmt.Sprintf("https://discord.com/channels/%s/%s/%s", panel.GuildID, panel.ChannelID, panel.MessageID)
+		var roles []string
+		for _, role := range panel.Roles {
+			roles = append(roles, fmt.Sprintf("%s<@&%s>", panelEmojiPrefix(role.Emoji), role.RoleID))
+		}
+		value := fmt.Sprintf("[%s](%s), %s: %s", panel.MessageID, link, panel.Style, strings.Join(roles, ", "))
+		if panel.MaxRoles > 0 {
+			value += fmt.Sprintf(" (max %d)", panel.MaxRoles)
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: panel.Title, Value: value})
+	}
+	ctx.ReplyEmbed(embed)
+}
+
+func handleRolePanelDelete(ctx *CommandContext, messageID string) {
+	db := guildDB(ctx.GuildID)
+	panel, err := fetchRolePanel(db, ctx.GuildID, messageID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch role panel.")
+		return
+	}
+	if panel == nil {
+		ctx.Reply("No role panel found for that message.")
+		return
+	}
+
+	if err := deleteRolePanel(db, messageID); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to delete role panel.")
+		return
+	}
+	ctx.Session.ChannelMessageDelete(panel.ChannelID, panel.MessageID)
+	ctx.Reply("Role panel deleted.")
+}
+
+func panelEmojiPrefix(emoji string) string {
+	if emoji == "" {
+		return ""
+	}
+	return displayEmoji(emoji) + " "
+}
+
+// role returns the panel entry for roleID, or nil.
+func (p *RolePanel) role(roleID string) *PanelRole {
+	for _, role := range p.Roles {
+		if role.RoleID == roleID {
+			return role
+		}
+	}
+	return nil
+}
+
+// components builds the buttons or select menu shown under the panel message.
+func (p *RolePanel) components() []discordgo.MessageComponent {
+	if p.Style == PanelStyleSelect {
+		minValues := 0
+		maxValues := len(p.Roles)
+		if p.MaxRoles > 0 && p.MaxRoles < maxValues {
+			maxValues = p.MaxRoles
+		}
+		menu := discordgo.SelectMenu{
+			CustomID:    rolePanelSelectID,
+			Placeholder: "Pick roles to add or remove",
+			MinValues:   &minValues,
+			MaxValues:   maxValues,
+		}
+		for _, role := range p.Roles {
+			menu.Options = append(menu.Options, discordgo.SelectMenuOption{
+				Label: role.Label,
+				Value: role.RoleID,
+				Emoji: componentEmoji(role.Emoji),
+			})
+		}
+		return []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{menu}}}
+	}
+
+	var rows []discordgo.MessageComponent
+	var row discordgo.ActionsRow
+	for _, role := range p.Roles {
+		row.Components = append(row.Components, discordgo.Button{
+			Label:    role.Label,
+			Style:    discordgo.SecondaryButton,
+			Emoji:    componentEmoji(role.Emoji),
+			CustomID: rolePanelToggleID + role.RoleID,
+		})
+		if len(row.Components) == 5 {
+			rows = append(rows, row)
+			row = discordgo.ActionsRow{}
+		}
+	}
+	if len(row.Components) > 0 {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// componentEmoji converts an emoji key to the form used by buttons and menus.
+func componentEmoji(key string) discordgo.ComponentEmoji {
+	if name, id, ok := strings.Cut(key, ":"); ok {
+		return discordgo.ComponentEmoji{Name: name, ID: id}
+	}
+	return discordgo.ComponentEmoji{Name: key}
+}
+
+// ===================================Panel interactions===========================================
+// handleRolePanelComponent answers a click on a role panel button or a choice
+// in its select menu. Results are only shown to the member who clicked.
+func handleRolePanelComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+	if i.Member == nil || i.Message == nil {
+		return
+	}
+
+	panel, err := fetchRolePanel(guildDB(i.GuildID), i.GuildID, i.Message.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	if panel == nil {
+		respondEphemeral(s, i, "This role panel is no longer active.")
+		return
+	}
+
+	held := make(map[string]bool)
+	for _, roleID := range i.Member.Roles {
+		held[roleID] = true
+	}
+	userID := i.Member.User.ID
+
+	var added, removed, failed []string
+	apply := func(roleID string, want bool) {
+		var err error
+		switch {
+		case want && !held[roleID]:
+			if err = s.GuildMemberRoleAdd(i.GuildID, userID, roleID); err == nil {
+				added = append(added, "<@&"+roleID+">")
+			}
+		case !want && held[roleID]:
+			if err = s.GuildMemberRoleRemove(i.GuildID, userID, roleID); err == nil {
+				removed = append(removed, "<@&"+roleID+">")
+			}
+		}
+		if err != nil {
+			log.Printf("Error updating role %s of %s: %v", roleID, userID, err)
+			failed = append(failed, "<@&"+roleID+">")
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(data.CustomID, rolePanelToggleID):
+		roleID := strings.TrimPrefix(data.CustomID, rolePanelToggleID)
+		if panel.role(roleID) == nil {
+			respondEphemeral(s, i, "That role is no longer on this panel.")
+			return
+		}
+		apply(roleID, !held[roleID])
+	case data.CustomID == rolePanelSelectID:
+		// The menu is shared by every member, so it cannot show which roles
+		// each one holds. A choice toggles the picked roles and leaves the
+		// others alone.
+		chosen := make(map[string]bool)
+		for _, value := range data.Values {
+			if panel.role(value) != nil {
+				chosen[value] = true
+			}
+		}
+		kept := 0
+		for _, role := range panel.Roles {
+			if held[role.RoleID] != chosen[role.RoleID] {
+				kept++
+			}
+		}
+		if panel.MaxRoles > 0 && kept > panel.MaxRoles {
+			respondEphemeral(s, i, fmt.Sprintf("You can hold at most %d role(s) from this panel. Pick roles you have to remove them first.", panel.MaxRoles))
+			return
+		}
+		for _, role := range panel.Roles {
+			if chosen[role.RoleID] {
+				apply(role.RoleID, !held[role.RoleID])
+			}
+		}
+	default:
+		return
+	}
+
+	var lines []string
+	if len(added) > 0 {
+		lines = append(lines, "Added "+strings.Join(added, ", ")+".")
+	}
+	if len(removed) > 0 {
+		lines = append(lines, "Removed "+strings.Join(removed, ", ")+".")
+	}
+	if len(failed) > 0 {
+		lines = append(lines, "Could not update "+strings.Join(failed, ", ")+". Please ask a teacher for help.")
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "Your roles are unchanged.")
+	}
+	respondEphemeral(s, i, strings.Join(lines, "\n"))
+}
+
+// respondEphemeral answers an interaction with a message only its user sees.
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to respond to interaction: %v", err)
+	}
+}
+
+// ===================================Panel database===========================================
+func fetchRolePanels(db *sql.DB, guildID string) ([]*RolePanel, error) {
+	rows, err := db.Query(`SELECT message_id FROM role_panels WHERE guild_id = ? ORDER BY message_id`, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching role panels: %v", err)
+	}
+	var messageIDs []string
+	for rows.Next() {
+		var messageID string
+		if err := rows.Scan(&messageID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error reading role panel: %v", err)
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through role panels: %v", err)
+	}
+
+	var panels []*RolePanel
+	for _, messageID := range messageIDs {
+		panel, err := fetchRolePanel(db, guildID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if panel != nil {
+			panels = append(panels, panel)
+		}
+	}
+	return panels, nil
+}
+
+// fetchRolePanel returns the panel on messageID, or nil if there is none.
+func fetchRolePanel(db *sql.DB, guildID, messageID string) (*RolePanel, error) {
+	panel := &RolePanel{MessageID: messageID}
+	err := db.QueryRow(`
+		SELECT guild_id, channel_id, style, title, max_roles FROM role_panels WHERE guild_id = ? AND message_id = ?
+	`, guildID, messageID).Scan(&panel.GuildID, &panel.ChannelID, &panel.Style, &panel.Title, &panel.MaxRoles)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching role panel: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT role_id, label, emoji FROM role_panel_roles WHERE message_id = ? ORDER BY position`, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching role panel roles: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role PanelRole
+		if err := rows.Scan(&role.RoleID, &role.Label, &role.Emoji); err != nil {
+			return nil, fmt.Errorf("error reading role panel role: %v", err)
+		}
+		panel.Roles = append(panel.Roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through role panel roles: %v", err)
+	}
+	return panel, nil
+}
+
+// saveRolePanel inserts or replaces the panel and its roles.
+func saveRolePanel(db *sql.DB, panel *RolePanel) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error saving role panel: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO role_panels (message_id, guild_id, channel_id, style, title, max_roles) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(message_id) DO UPDATE SET style = excluded.style, title = excluded.title, max_roles = excluded.max_roles
+	`, panel.MessageID, panel.GuildID, panel.ChannelID, panel.Style, panel.Title, panel.MaxRoles)
+	if err != nil {
+		return fmt.Errorf("error saving role panel: %v", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM role_panel_roles WHERE message_id = ?`, panel.MessageID); err != nil {
+		return fmt.Errorf("error saving role panel roles: %v", err)
+	}
+	for position, role := range panel.Roles {
+		_, err = tx.Exec(`INSERT INTO role_panel_roles (message_id, role_id, label, emoji, position) VALUES (?, ?, ?, ?, ?)`,
+			panel.MessageID, role.RoleID, role.Label, role.Emoji, position)
+		if err != nil {
+			return fmt.Errorf("error saving role panel roles: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func deleteRolePanel(db *sql.DB, messageID string) error {
+	if _, err := db.Exec(`DELETE FROM role_panel_roles WHERE message_id = ?`, messageID); err != nil {
+		return fmt.Errorf("error deleting role panel roles: %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM role_panels WHERE message_id = ?`, messageID); err != nil {
+		return fmt.Errorf("error deleting role panel: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// rosterSummaryDelay collects role changes made in quick succession (say, a
+// teacher assigning a role to a whole class) into one summary message.
+const rosterSummaryDelay = time.Minute
+
+// manualEnrollment is the role ID of enrollments started by adding or
+// importing a student by hand rather than through a roster role.
+const manualEnrollment = ""
+
+// RosterChange is a member starting or ending an enrollment through a roster role.
+type RosterChange struct {
+	UserID   string
+	Username string
+	RoleID   string
+	Enrolled bool // false when the enrollment ended
+}
+
+// ==================================ROSTER SYNC===========================================
+// Members holding a roster role are students. Gaining the role starts an
+// enrollment and (re)activates the student; losing it, or leaving the server,
+// ends the enrollment and deactivates the student once no roster role is left.
+// Inactive students keep their details but are left out of attendance.
+// Manual enrollments are left alone until the student loses their last roster
+// role.
+
+// syncMemberRoster brings user's enrollments in line with memberRoles, which
+// is nil for a member who left. Callers run it inside a transaction.
+func syncMemberRoster(tx *sql.Tx, guildID string, rosterRoles map[string]bool, user *discordgo.User, memberRoles []string) ([]RosterChange, error) {
+	holding := make(map[string]bool)
+	for _, roleID := range memberRoles {
+		if rosterRoles[roleID] {
+			holding[roleID] = true
+		}
+	}
+	open, err := fetchOpenEnrollments(tx, guildID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	delete(open, manualEnrollment)
+	if len(holding) == 0 && len(open) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	var changes []RosterChange
+	for roleID := range holding {
+		if open[roleID] {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO enrollments (guild_id, user_id, role_id, started_at) VALUES (?, ?, ?, ?)`,
+			guildID, user.ID, roleID, now)
+		if err != nil {
+			return nil, fmt.Errorf("error starting enrollment: %v", err)
+		}
+		changes = append(changes, RosterChange{UserID: user.ID, Username: user.Username, RoleID: roleID, Enrolled: true})
+	}
+	for roleID := range open {
+		if holding[roleID] {
+			continue
+		}
+		_, err := tx.Exec(`UPDATE enrollments SET ended_at = ? WHERE guild_id = ? AND user_id = ? AND role_id = ? AND ended_at IS NULL`,
+			now, guildID, user.ID, roleID)
+		if err != nil {
+			return nil, fmt.Errorf("error ending enrollment: %v", err)
+		}
+		changes = append(changes, RosterChange{UserID: user.ID, Username: user.Username, RoleID: roleID})
+	}
+
+	if len(holding) > 0 {
+		if err := saveStudent(tx, guildID, Student{UserID: user.ID, Username: user.Username}); err != nil {
+			return nil, err
+		}
+	} else {
+		_, err := tx.Exec(`UPDATE students SET active = 0 WHERE guild_id = ? AND user_id = ?`, guildID, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error deactivating student: %v", err)
+		}
+		_, err = tx.Exec(`UPDATE enrollments SET ended_at = ? WHERE guild_id = ? AND user_id = ? AND role_id = ? AND ended_at IS NULL`,
+			now, guildID, user.ID, manualEnrollment)
+		if err != nil {
+			return nil, fmt.Errorf("error ending enrollment: %v", err)
+		}
+	}
+	return changes, nil
+}
+
+// rosterMemberChanged syncs one member after a join, role change or leave
+// and queues the changes for the teacher channel.
+func rosterMemberChanged(s *discordgo.Session, guildID string, user *discordgo.User, memberRoles []string) {
+	if user == nil || user.Bot {
+		return
+	}
+	db := guildDB(guildID)
+	roles, err := fetchRosterRoles(db, guildID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(roles) == 0 {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	changes, err := syncMemberRoster(tx, guildID, roles, user, memberRoles)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Failed to sync roster for %s in guild %s: %v", user.ID, guildID, err)
+		return
+	}
+	queueRosterSummary(s, guildID, changes)
+}
+
+// reconcileRoster syncs every member of guildID, catching up on role changes
+// and departures missed while the bot was offline.
+func reconcileRoster(s *discordgo.Session, guildID string) ([]RosterChange, error) {
+	db := guildDB(guildID)
+	needed, err := rosterInUse(db, guildID)
+	if err != nil || !needed {
+		return nil, err
+	}
+	members, err := fetchAllMembers(s, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching guild members: %v", err)
+	}
+	return reconcileRosterMembers(db, guildID, members)
+}
+
+// rosterInUse reports whether guildID has roster roles or open enrollments
+// left over from removed ones.
+func rosterInUse(db *sql.DB, guildID string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM roster_roles WHERE guild_id = ?)
+		     + (SELECT COUNT(*) FROM enrollments WHERE guild_id = ? AND role_id != ? AND ended_at IS NULL)
+	`, guildID, guildID, manualEnrollment).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking roster roles: %v", err)
+	}
+	return count > 0, nil
+}
+
+// reconcileRosterMembers syncs members, the complete member list of guildID,
+// in one transaction. Enrolled users missing from members have left.
+func reconcileRosterMembers(db *sql.DB, guildID string, members []*discordgo.Member) ([]RosterChange, error) {
+	roles, err := fetchRosterRoles(db, guildID)
+	if err != nil {
+		return nil, err
+	}
+	enrolled, err := fetchEnrolledUsers(db, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var changes []RosterChange
+	present := make(map[string]bool, len(members))
+	for _, member := range members {
+		present[member.User.ID] = true
+		if member.User.Bot {
+			continue
+		}
+		memberChanges, err := syncMemberRoster(tx, guildID, roles, member.User, member.Roles)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, memberChanges...)
+	}
+
+	for userID, username := range enrolled {
+		if present[userID] {
+			continue
+		}
+		memberChanges, err := syncMemberRoster(tx, guildID, roles, &discordgo.User{ID: userID, Username: username}, nil)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, memberChanges...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error saving roster: %v", err)
+	}
+	return changes, nil
+}
+
+// ===================================Roster summaries===========================================
+var (
+	rosterSummaryMu sync.Mutex
+	rosterPending   = make(map[string][]RosterChange) // By guild ID
+)
+
+// queueRosterSummary adds changes to the guild's next summary, which is
+// posted rosterSummaryDelay after the first queued change.
+func queueRosterSummary(s *discordgo.Session, guildID string, changes []RosterChange) {
+	if len(changes) == 0 {
+		return
+	}
+	rosterSummaryMu.Lock()
+	defer rosterSummaryMu.Unlock()
+	if len(rosterPending[guildID]) == 0 {
+		time.AfterFunc(rosterSummaryDelay, func() { postRosterSummary(s, guildID) })
+	}
+	rosterPending[guildID] = append(rosterPending[guildID], changes...)
+}
+
+func postRosterSummary(s *discordgo.Session, guildID string) {
+	rosterSummaryMu.Lock()
+	changes := rosterPending[guildID]
+	delete(rosterPending, guildID)
+	rosterSummaryMu.Unlock()
+
+	embed := rosterSummaryEmbed(changes)
+	if embed == nil {
+		return
+	}
+	channelID := guildTeacherChannel(guildID)
+	if channelID == "" {
+		log.Printf("Roster changes in guild %s: %s", guildID, embed.Description)
+		return
+	}
+	if _, err := s.ChannelMessageSendEmbed(channelID, embed); err != nil {
+		log.Printf("Failed to post roster summary to %s: %v", channelID, err)
+	}
+}
+
+// rosterSummaryEmbed lists who was enrolled and unenrolled, per role.
+func rosterSummaryEmbed(changes []RosterChange) *discordgo.MessageEmbed {
+	if len(changes) == 0 {
+		return nil
+	}
+	enrolled := make(map[string][]string)
+	ended := make(map[string][]string)
+	for _, change := range changes {
+		if change.Enrolled {
+			enrolled[change.RoleID] = append(enrolled[change.RoleID], "<@"+change.UserID+">")
+		} else {
+			ended[change.RoleID] = append(ended[change.RoleID], "<@"+change.UserID+">")
+		}
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Roster changes",
+		Description: fmt.Sprintf("%d enrollment changes.", len(changes)),
+		Color:       0x00ff00, // Green color
+	}
+	addFields := func(label string, byRole map[string][]string) {
+		roleIDs := make([]string, 0, len(byRole))
+		for roleID := range byRole {
+			roleIDs = append(roleIDs, roleID)
+		}
+		sort.Strings(roleIDs)
+		for _, roleID := range roleIDs {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("%s (%d)", label, len(byRole[roleID])),
+				Value: truncateList(fmt.Sprintf("<@&%s>: ", roleID), byRole[roleID], 1024),
+			})
+		}
+	}
+	addFields("Enrolled", enrolled)
+	addFields("Unenrolled", ended)
+	return embed
+}
+
+// truncateList joins items after prefix, ending with "and N more" when they
+// would not fit in limit characters.
+func truncateList(prefix string, items []string, limit int) string {
+	out := prefix
+	for i, item := range items {
+		sep := ""
+		if i > 0 {
+			sep = ", "
+		}
+		more := fmt.Sprintf(" and %d more", len(items)-i)
+		if len(out)+len(sep)+len(item)+len(more) > limit {
+			return out + more
+		}
+		out += sep + item
+	}
+	return out
+}
+
+func handleRosterRole(ctx *CommandContext, action string, role *discordgo.Role) {
+	db := guildDB(ctx.GuildID)
+	switch action {
+	case "add":
+		if err := saveRosterRole(db, ctx.GuildID, role.ID); err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to save the roster role.")
+			return
+		}
+		ctx.Defer()
+		changes, err := reconcileRoster(ctx.Session, ctx.GuildID)
+		if err != nil {
+			log.Println(err)
+			ctx.Reply(fmt.Sprintf("Members with '%s' will now be kept on the roster, but syncing the current members failed.", role.Name))
+			return
+		}
+		ctx.Reply(fmt.Sprintf("Members with '%s' are now kept on the roster. %d enrollments changed.", role.Name, len(changes)))
+	case "remove":
+		if err := deleteRosterRole(db, ctx.GuildID, role.ID); err != nil {
+			log.Println(err)
+			ctx.Reply("Failed to remove the roster role.")
+			return
+		}
+		ctx.Defer()
+		changes, err := reconcileRoster(ctx.Session, ctx.GuildID)
+		if err != nil {
+			log.Println(err)
+			ctx.Reply(fmt.Sprintf("'%s' is no longer a roster role, but ending its enrollments failed.", role.Name))
+			return
+		}
+		ctx.Reply(fmt.Sprintf("'%s' is no longer a roster role. %d enrollments ended.", role.Name, len(changes)))
+	}
+}
+
+func handleRosterRoleList(ctx *CommandContext) {
+	roles, err := fetchRosterRoles(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch roster roles.")
+		return
+	}
+	var mentions []string
+	for roleID := range roles {
+		mentions = append(mentions, "<@&"+roleID+">")
+	}
+	sort.Strings(mentions)
+
+	roleList := "none"
+	if len(mentions) > 0 {
+		roleList = strings.Join(mentions, ", ")
+	}
+	channel := "not set"
+	if channelID := guildTeacherChannel(ctx.GuildID); channelID != "" {
+		channel = "<#" + channelID + ">"
+	}
+	embed := &discordgo.MessageEmbed{
+		Title: "Roster Sync",
+		Color: 0x00ff00, // Green color
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Roster roles", Value: roleList},
+			{Name: "Summary channel", Value: channel},
+		},
+	}
+	ctx.ReplyEmbed(embed)
+}
+
+func handleRosterChannel(ctx *CommandContext, channelID string) {
+	if err := setGuildTeacherChannel(guildDB(ctx.GuildID), ctx.GuildID, channelID); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save the summary channel.")
+		return
+	}
+	if channelID == "" {
+		ctx.Reply("Roster changes will no longer be posted.")
+		return
+	}
+	ctx.Reply(fmt.Sprintf("Roster changes will be posted in <#%s>.", channelID))
+}
+
+// ===================================Roster database===========================================
+func fetchRosterRoles(db *sql.DB, guildID string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT role_id FROM roster_roles WHERE guild_id = ?`, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching roster roles: %v", err)
+	}
+	defer rows.Close()
+
+	roles := make(map[string]bool)
+	for rows.Next() {
+		var roleID string
+		if err := rows.Scan(&roleID); err != nil {
+			return nil, fmt.Errorf("error reading roster role: %v", err)
+		}
+		roles[roleID] = true
+	}
+	return roles, rows.Err()
+}
+
+func saveRosterRole(db *sql.DB, guildID, roleID string) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO roster_roles (guild_id, role_id) VALUES (?, ?)`, guildID, roleID)
+	if err != nil {
+		return fmt.Errorf("error saving roster role: %v", err)
+	}
+	return nil
+}
+
+func deleteRosterRole(db *sql.DB, guildID, roleID string) error {
+	_, err := db.Exec(`DELETE FROM roster_roles WHERE guild_id = ? AND role_id = ?`, guildID, roleID)
+	if err != nil {
+		return fmt.Errorf("error deleting roster role: %v", err)
+	}
+	return nil
+}
+
+// fetchOpenEnrollments returns the roles through which userID is enrolled.
+func fetchOpenEnrollments(db dbExecutor, guildID, userID string) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT role_id FROM enrollments WHERE guild_id = ? AND user_id = ? AND ended_at IS NULL`, guildID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching enrollments: %v", err)
+	}
+	defer rows.Close()
+
+	open := make(map[string]bool)
+	for rows.Next() {
+		var roleID string
+		if err := rows.Scan(&roleID); err != nil {
+			return nil, fmt.Errorf("error reading enrollment: %v", err)
+		}
+		open[roleID] = true
+	}
+	return open, rows.Err()
+}
+
+// startManualEnrollment enrolls userID from now on unless they are already
+// enrolled in some way.
+func startManualEnrollment(db dbExecutor, guildID, userID string) error {
+	_, err := db.Exec(`
+		INSERT INTO enrollments (guild_id, user_id, role_id, started_at)
+		SELECT ?, ?, ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM enrollments WHERE guild_id = ? AND user_id = ? AND ended_at IS NULL)
+	`, guildID, userID, manualEnrollment, time.Now().UTC(), guildID, userID)
+	if err != nil {
+		return fmt.Errorf("error starting enrollment: %v", err)
+	}
+	return nil
+}
+
+// fetchEnrolledUsers returns the usernames of users with an open enrollment, by ID.
+func fetchEnrolledUsers(db *sql.DB, guildID string) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT e.user_id, COALESCE(s.username, '')
+		FROM enrollments e
+		LEFT JOIN students s ON s.guild_id = e.guild_id AND s.user_id = e.user_id
+		WHERE e.guild_id = ? AND e.role_id != ? AND e.ended_at IS NULL
+	`, guildID, manualEnrollment)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching enrolled users: %v", err)
+	}
+	defer rows.Close()
+
+	users := make(map[string]string)
+	for rows.Next() {
+		var userID, username string
+		if err := rows.Scan(&userID, &username); err != nil {
+			return nil, fmt.Errorf("error reading enrolled user: %v", err)
+		}
+		users[userID] = username
+	}
+	return users, rows.Err()
+}
+
+// Enrollments records when each student was enrolled, so reports on past
+// sessions include the students of that time rather than today's roster.
+type Enrollments struct {
+	students []Student
+	spans    map[string][]enrollmentSpan
+}
+
+// enrollmentSpan is one enrollment; end is zero while it is open.
+type enrollmentSpan struct {
+	start, end time.Time
+}
+
+// EnrolledAt reports whether userID was enrolled at t: enrolled at or before
+// t and not yet left.
+func (e *Enrollments) EnrolledAt(userID string, t time.Time) bool {
+	for _, span := range e.spans[userID] {
+		if !span.start.After(t) && (span.end.IsZero() || span.end.After(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// StudentsAt returns the students enrolled at t, in roster order.
+func (e *Enrollments) StudentsAt(t time.Time) []Student {
+	var students []Student
+	for _, student := range e.students {
+		if e.EnrolledAt(student.UserID, t) {
+			students = append(students, student)
+		}
+	}
+	return students
+}
+
+// StudentsIn returns the students enrolled at the start of any of sessions,
+// in roster order.
+func (e *Enrollments) StudentsIn(sessions []ClassSession) []Student {
+	var students []Student
+	for _, student := range e.students {
+		for _, session := range sessions {
+			if e.EnrolledAt(student.UserID, session.ScheduledStart) {
+				students = append(students, student)
+				break
+			}
+		}
+	}
+	return students
+}
+
+// fetchEnrollments loads every enrollment of guildID. Active students from
+// before enrollments were recorded have none and count as always enrolled.
+func fetchEnrollments(db *sql.DB, guildID string) (*Enrollments, error) {
+	rows, err := db.Query(`
+		SELECT s.user_id, s.username, s.real_name, s.student_number, s.section, s.active, e.started_at, e.ended_at
+		FROM students s
+		LEFT JOIN enrollments e ON e.guild_id = s.guild_id AND e.user_id = s.user_id
+		WHERE s.guild_id = ?
+		ORDER BY s.id, e.started_at
+	`, guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching enrollments: %v", err)
+	}
+	defer rows.Close()
+
+	enrollments := &Enrollments{spans: make(map[string][]enrollmentSpan)}
+	for rows.Next() {
+		var student Student
+		var active bool
+		var startedAt, endedAt sql.NullTime
+		err := rows.Scan(&student.UserID, &student.Username, &student.RealName, &student.StudentNumber, &student.Section,
+			&active, &startedAt, &endedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading enrollment: %v", err)
+		}
+		if !startedAt.Valid && !active {
+			continue
+		}
+		if _, seen := enrollments.spans[student.UserID]; !seen {
+			enrollments.students = append(enrollments.students, student)
+		}
+		// A zero start with no end covers all time
+		enrollments.spans[student.UserID] = append(enrollments.spans[student.UserID],
+			enrollmentSpan{start: startedAt.Time, end: endedAt.Time})
+	}
+	return enrollments, rows.Err()
+}
+
+// guildTeacherChannel returns the channel roster summaries are posted to, or "".
+func guildTeacherChannel(guildID string) string {
+	var channelID string
+	err := guildDB(guildID).QueryRow("SELECT teacher_channel_id FROM guild_settings WHERE guild_id = ?", guildID).Scan(&channelID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error fetching teacher channel for guild %s: %v", guildID, err)
+	}
+	return channelID
+}
+
+func setGuildTeacherChannel(db *sql.DB, guildID, channelID string) error {
+	_, err := db.Exec(`
+		INSERT INTO guild_settings (guild_id, teacher_channel_id) VALUES (?, ?)
+		ON CONFLICT(guild_id) DO UPDATE SET teacher_channel_id = excluded.teacher_channel_id
+	`, guildID, channelID)
+	if err != nil {
+		return fmt.Errorf("error saving teacher channel: %v", err)
+	}
+	return nil
+}
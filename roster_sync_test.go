@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// insertEnrollment records an enrollment; a zero end leaves it open.
+func insertEnrollment(t *testing.T, conn *sql.DB, userID, roleID string, start, end time.Time) {
+	t.Helper()
+	var endedAt interface{}
+	if !end.IsZero() {
+		endedAt = end
+	}
+	_, err := conn.Exec(`INSERT INTO enrollments (guild_id, user_id, role_id, started_at, ended_at) VALUES (?, ?, ?, ?, ?)`,
+		"g1", userID, roleID, start, endedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func studentIDs(students []Student) []string {
+	var ids []string
+	for _, student := range students {
+		ids = append(ids, student.UserID)
+	}
+	return ids
+}
+
+func TestEnrollmentsPerSession(t *testing.T) {
+	conn := useTestDatabase(t)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 9, 0, 0, 0, time.UTC) }
+	for _, student := range []Student{
+		{UserID: "left", Username: "left"},
+		{UserID: "joined", Username: "joined"},
+		{UserID: "legacy", Username: "legacy"},
+		{UserID: "legacyRemoved", Username: "legacyRemoved"},
+	} {
+		if err := saveStudent(conn, "g1", student); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Exec(`UPDATE students SET active = 0 WHERE user_id IN ('left', 'legacyRemoved')`); err != nil {
+		t.Fatal(err)
+	}
+	insertEnrollment(t, conn, "left", "r1", day(1), day(5))
+	insertEnrollment(t, conn, "joined", "r1", day(3), time.Time{})
+
+	enrollments, err := fetchEnrollments(conn, "g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at   time.Time
+		want []string
+	}{
+		{day(1), []string{"left", "legacy"}},
+		{day(3), []string{"left", "joined", "legacy"}},
+		{day(5), []string{"joined", "legacy"}},
+	}
+	for _, tt := range tests {
+		if got := studentIDs(enrollments.StudentsAt(tt.at)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("students at %s = %v, want %v", tt.at.Format(reportDateLayout), got, tt.want)
+		}
+	}
+
+	sessions := []ClassSession{{ScheduledStart: day(6)}, {ScheduledStart: day(1)}}
+	if got, want := studentIDs(enrollments.StudentsIn(sessions)), []string{"left", "joined", "legacy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("students in sessions = %v, want %v", got, want)
+	}
+}
+
+func TestManualEnrollmentSurvivesRosterSync(t *testing.T) {
+	conn := useTestDatabase(t)
+	if _, err := upsertStudent(conn, "g1", Student{UserID: "u1", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upsertStudent(conn, "g1", Student{UserID: "u1", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM enrollments WHERE user_id = 'u1' AND ended_at IS NULL"); n != 1 {
+		t.Fatalf("%d open enrollments after adding the student twice, want 1", n)
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := syncMemberRoster(tx, "g1", map[string]bool{"r1": true}, &discordgo.User{ID: "u1", Username: "alice"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("roster sync changed a manually added student: %+v", changes)
+	}
+	ids, err := fetchActiveStudentIDs(conn, "g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ids["u1"] {
+		t.Error("roster sync deactivated a manually added student")
+	}
+}
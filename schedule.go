@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ==================================CLASS SCHEDULES===========================================
+const defaultClassName = "default"
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ClassSchedule is a recurring class stored in the class_schedules table.
+// StartTime is the wall clock time ("15:04") in Timezone.
+type ClassSchedule struct {
+	GuildID      string
+	Name         string
+	Weekdays     []time.Weekday // Empty means every day
+	StartTime    string
+	Duration     time.Duration
+	Timezone     string
+	VoiceChannel string
+}
+
+// Location returns the schedule's timezone, falling back to UTC if it can
+// no longer be loaded.
+func (c *ClassSchedule) Location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		log.Printf("Invalid timezone %q for class %s: %v", c.Timezone, c.Name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// StartOn returns the UTC start of the class on the calendar day of day, as
+// seen in the schedule's timezone.
+func (c *ClassSchedule) StartOn(day time.Time) time.Time {
+	loc := c.Location()
+	clock, err := time.Parse("15:04", c.StartTime)
+	if err != nil {
+		log.Printf("Invalid start time %q for class %s: %v", c.StartTime, c.Name, err)
+	}
+	local := day.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc).UTC()
+}
+
+// OccursOn reports whether the class meets on weekday.
+func (c *ClassSchedule) OccursOn(weekday time.Weekday) bool {
+	if len(c.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range c.Weekdays {
+		if d == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// MeetsOn reports whether the class meets on the calendar day of t, as seen
+// in the schedule's timezone.
+func (c *ClassSchedule) MeetsOn(t time.Time) bool {
+	return c.OccursOn(t.In(c.Location()).Weekday())
+}
+
+// NextStart returns the UTC start of the first meeting after t.
+func (c *ClassSchedule) NextStart(t time.Time) time.Time {
+	for days := 0; days <= 7; days++ {
+		day := t.In(c.Location()).AddDate(0, 0, days)
+		if start := c.StartOn(day); c.MeetsOn(day) && start.After(t) {
+			return start
+		}
+	}
+	return c.StartOn(t)
+}
+
+// WeekdaysString formats Weekdays as "mon,wed", or "every day".
+func (c *ClassSchedule) WeekdaysString() string {
+	if len(c.Weekdays) == 0 {
+		return "every day"
+	}
+	return formatWeekdays(c.Weekdays)
+}
+
+func formatWeekdays(days []time.Weekday) string {
+	names := make([]string, len(days))
+	for i, d := range days {
+		names[i] = weekdayNames[d]
+	}
+	return strings.Join(names, ",")
+}
+
+// parseWeekdays parses a comma separated list such as "mon,wed,fri". An empty
+// string or "daily" means every day.
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" || s == "daily" {
+		return nil, nil
+	}
+
+	seen := make(map[time.Weekday]bool)
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		found := false
+		for i, name := range weekdayNames {
+			if len(part) >= 3 && strings.HasPrefix(part, name) {
+				if !seen[time.Weekday(i)] {
+					seen[time.Weekday(i)] = true
+					days = append(days, time.Weekday(i))
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown weekday %q", part)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+	return days, nil
+}
+
+// ===================================Schedule cache===========================================
+// Every guild's schedules are cached in its GuildState. The cache is loaded
+// from the database on startup and kept in sync by saveClassSchedule and
+// deleteClassSchedule.
+
+// loadClassSchedules fills the cache from every open database.
+func loadClassSchedules() error {
+	count := 0
+	for path, conn := range databases {
+		schedules, err := fetchClassSchedules(conn, "")
+		if err != nil {
+			return fmt.Errorf("error loading class schedules from %s: %v", path, err)
+		}
+		for _, schedule := range schedules {
+			guildStates.Guild(schedule.GuildID).CacheSchedule(schedule)
+			count++
+		}
+	}
+	log.Printf("Loaded %d class schedules", count)
+	return nil
+}
+
+// guildClassSchedules returns a guild's schedules ordered by start time.
+func guildClassSchedules(guildID string) []*ClassSchedule {
+	return guildStates.Guild(guildID).Schedules()
+}
+
+func findClassSchedule(guildID, name string) (*ClassSchedule, bool) {
+	return guildStates.Guild(guildID).Schedule(name)
+}
+
+// scheduleForSheet picks the schedule for an attendance sheet: the class with
+// the same name, or the guild's only class.
+func scheduleForSheet(guildID, sheetName string) (*ClassSchedule, bool) {
+	if schedule, ok := findClassSchedule(guildID, sheetName); ok {
+		return schedule, true
+	}
+	if schedules := guildClassSchedules(guildID); len(schedules) == 1 {
+		return schedules[0], true
+	}
+	return nil, false
+}
+
+// ===================================Schedule database===========================================
+func fetchClassSchedules(db *sql.DB, guildID string) ([]*ClassSchedule, error) {
+	query := `SELECT guild_id, class_name, weekdays, start_time, duration_minutes, timezone, voice_channel FROM class_schedules`
+	var args []interface{}
+	if guildID != "" {
+		query += ` WHERE guild_id = ?`
+		args = append(args, guildID)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching class schedules: %v", err)
+	}
+	defer rows.Close()
+
+	var schedules []*ClassSchedule
+	for rows.Next() {
+		var schedule ClassSchedule
+		var weekdays string
+		var minutes int
+		if err := rows.Scan(&schedule.GuildID, &schedule.Name, &weekdays, &schedule.StartTime, &minutes, &schedule.Timezone, &schedule.VoiceChannel); err != nil {
+			return nil, fmt.Errorf("error reading class schedule: %v", err)
+		}
+		schedule.Weekdays, err = parseWeekdays(weekdays)
+		if err != nil {
+			log.Printf("Ignoring weekdays of class %s: %v", schedule.Name, err)
+		}
+		schedule.Duration = time.Duration(minutes) * time.Minute
+		schedules = append(schedules, &schedule)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating through class schedules: %v", err)
+	}
+	return schedules, nil
+}
+
+// saveClassSchedule inserts or replaces the schedule and updates the cache.
+func saveClassSchedule(db *sql.DB, schedule *ClassSchedule) error {
+	_, err := db.Exec(`
+		INSERT INTO class_schedules (guild_id, class_name, weekdays, start_time, duration_minutes, timezone, voice_channel)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(guild_id, class_name) DO UPDATE SET
+			class_name = excluded.class_name,
+			weekdays = excluded.weekdays,
+			start_time = excluded.start_time,
+			duration_minutes = excluded.duration_minutes,
+			timezone = excluded.timezone,
+			voice_channel = excluded.voice_channel
+	`, schedule.GuildID, schedule.Name, formatWeekdays(schedule.Weekdays), schedule.StartTime,
+		int(schedule.Duration/time.Minute), schedule.Timezone, schedule.VoiceChannel)
+	if err != nil {
+		return fmt.Errorf("error saving class schedule: %v", err)
+	}
+	guildStates.Guild(schedule.GuildID).CacheSchedule(schedule)
+	return nil
+}
+
+// deleteClassSchedule removes the named class and updates the cache.
+func deleteClassSchedule(db *sql.DB, guildID, name string) error {
+	schedule, ok := findClassSchedule(guildID, name)
+	if !ok {
+		return nil
+	}
+	_, err := db.Exec(`DELETE FROM class_schedules WHERE guild_id = ? AND class_name = ?`, guildID, schedule.Name)
+	if err != nil {
+		return fmt.Errorf("error deleting class schedule: %v", err)
+	}
+	guildStates.Guild(guildID).UncacheSchedule(name)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================================SESSION MANAGER===========================================
+// AttendanceJob keeps one attendance sheet up to date while its class runs.
+type AttendanceJob struct {
+	GuildID   string
+	SheetName string
+	Class     *ClassSchedule
+	StartedAt time.Time // When the job was started
+
+	mu      sync.Mutex
+	session *ClassSession
+	cancel  context.CancelFunc
+}
+
+func newAttendanceJob(session *ClassSession, class *ClassSchedule) *AttendanceJob {
+	return &AttendanceJob{
+		GuildID:   session.GuildID,
+		SheetName: session.SheetName,
+		Class:     class,
+		StartedAt: session.ActualStart,
+		session:   session,
+	}
+}
+
+// Session returns a copy of the job's class session.
+func (j *AttendanceJob) Session() ClassSession {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return *j.session
+}
+
+// Stopped reports whether the job was stopped before the class ended.
+func (j *AttendanceJob) Stopped() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.session.StoppedBy != ""
+}
+
+// finish closes the job's class session at endTime unless it was already
+// stopped, and returns the closed session. A shutdown leaves the end unset:
+// the class goes on and its session is resumed when the bot is back.
+func (j *AttendanceJob) finish(endTime time.Time, stoppedBy string) ClassSession {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.session.StoppedBy == "" {
+		if stoppedBy != stoppedByShutdown {
+			j.session.ActualEnd = endTime
+		}
+		j.session.StoppedBy = stoppedBy
+	}
+	return *j.session
+}
+
+func (j *AttendanceJob) key() string {
+	return j.GuildID + "/" + strings.ToLower(j.SheetName)
+}
+
+// SessionManager owns the running attendance jobs, at most one per guild and
+// sheet. Every job runs in its own goroutine with a cancellable context.
+type SessionManager struct {
+	mu   sync.Mutex
+	jobs map[string]*AttendanceJob
+	wg   sync.WaitGroup
+}
+
+var sessionManager = &SessionManager{jobs: make(map[string]*AttendanceJob)}
+
+// Start runs job until run returns or the job is cancelled. A job already
+// running for the same sheet is cancelled and replaced; the replaced job is
+// returned.
+func (m *SessionManager) Start(job *AttendanceJob, run func(ctx context.Context, job *AttendanceJob)) *AttendanceJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	job.cancel = cancel
+
+	m.mu.Lock()
+	replaced := m.jobs[job.key()]
+	m.jobs[job.key()] = job
+	m.wg.Add(1)
+	m.mu.Unlock()
+
+	if replaced != nil {
+		replaced.finish(time.Now().UTC(), stoppedByReplaced)
+		replaced.cancel()
+		log.Printf("Replaced attendance job for %s", replaced.SheetName)
+	}
+
+	go func() {
+		defer m.wg.Done()
+		defer m.remove(job)
+		run(ctx, job)
+	}()
+	return replaced
+}
+
+// Stop ends the guild's job for sheetName, or all of its jobs when sheetName
+// is empty. The class of a stopped job ends at endTime.
+func (m *SessionManager) Stop(guildID, sheetName string, endTime time.Time, stoppedBy string) []*AttendanceJob {
+	var stopped []*AttendanceJob
+	for _, job := range m.Jobs(guildID) {
+		if sheetName != "" && !strings.EqualFold(job.SheetName, sheetName) {
+			continue
+		}
+		job.finish(endTime, stoppedBy)
+		job.cancel()
+		stopped = append(stopped, job)
+	}
+	return stopped
+}
+
+// Jobs returns the guild's running jobs ordered by sheet name.
+func (m *SessionManager) Jobs(guildID string) []*AttendanceJob {
+	m.mu.Lock()
+	var jobs []*AttendanceJob
+	for _, job := range m.jobs {
+		if job.GuildID == guildID {
+			jobs = append(jobs, job)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].SheetName < jobs[j].SheetName })
+	return jobs
+}
+
+// Shutdown cancels every job and waits up to timeout for them to return.
+func (m *SessionManager) Shutdown(timeout time.Duration) {
+	m.mu.Lock()
+	for _, job := range m.jobs {
+		job.finish(time.Now().UTC(), stoppedByShutdown)
+		job.cancel()
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("All attendance jobs stopped.")
+	case <-time.After(timeout):
+		log.Println("Timed out waiting for attendance jobs to stop.")
+	}
+}
+
+func (m *SessionManager) remove(job *AttendanceJob) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.jobs[job.key()] == job {
+		delete(m.jobs, job.key())
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ===================================Google Sheets exporter===========================================
+// SheetsExporter keeps every sheet as a tab of the guild's Google spreadsheet.
+// Roster and header changes are held back and written together with the
+// marks, so an update costs two reads and one write.
+type SheetsExporter struct {
+	sheets        *SheetsScheduler
+	spreadsheetID string
+
+	mu      sync.Mutex
+	pending map[string][]*sheets.ValueRange // By sheet name
+}
+
+func newSheetsExporter(scheduler *SheetsScheduler, spreadsheetID string) *SheetsExporter {
+	return &SheetsExporter{
+		sheets:        scheduler,
+		spreadsheetID: spreadsheetID,
+		pending:       make(map[string][]*sheets.ValueRange),
+	}
+}
+
+func (e *SheetsExporter) queue(sheetName string, vr *sheets.ValueRange) {
+	e.mu.Lock()
+	e.pending[sheetName] = append(e.pending[sheetName], vr)
+	e.mu.Unlock()
+}
+
+// sheetID returns the numeric ID of the tab titled sheetName, or -1 when it
+// does not exist.
+func (e *SheetsExporter) sheetID(sheetName string) (int64, error) {
+	var spreadsheet *sheets.Spreadsheet
+	err := e.sheets.Do("get spreadsheet", func() (err error) {
+		spreadsheet, err = e.sheets.srv.Spreadsheets.Get(e.spreadsheetID).Fields("sheets.properties(sheetId,title)").Do()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to access the spreadsheet: %v", err)
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties.Title == sheetName {
+			return sheet.Properties.SheetId, nil
+		}
+	}
+	return -1, nil
+}
+
+func (e *SheetsExporter) SheetExists(sheetName string) (bool, error) {
+	id, err := e.sheetID(sheetName)
+	return id >= 0, err
+}
+
+func (e *SheetsExporter) updateSpreadsheet(op string, requests ...*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	var resp *sheets.BatchUpdateSpreadsheetResponse
+	req := &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}
+	err := e.sheets.Do(op, func() (err error) {
+		resp, err = e.sheets.srv.Spreadsheets.BatchUpdate(e.spreadsheetID, req).Do()
+		return err
+	})
+	return resp, err
+}
+
+// hideIDColumn hides the user ID column, which only the bot needs.
+func hideIDColumn(sheetID int64) *sheets.Request {
+	return &sheets.Request{
+		UpdateDimensionProperties: &sheets.UpdateDimensionPropertiesRequest{
+			Range:      &sheets.DimensionRange{SheetId: sheetID, Dimension: "COLUMNS", StartIndex: 0, EndIndex: 1},
+			Properties: &sheets.DimensionProperties{HiddenByUser: true},
+			Fields:     "hiddenByUser",
+		},
+	}
+}
+
+func (e *SheetsExporter) CreateRosterSheet(sheetName string, students []Student) (string, error) {
+	// Add a new sheet to the existing spreadsheet
+	resp, err := e.updateSpreadsheet("add sheet", &sheets.Request{
+		AddSheet: &sheets.AddSheetRequest{
+			Properties: &sheets.SheetProperties{Title: sheetName},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create new sheet: %v", err)
+	}
+	newSheetID := resp.Replies[0].AddSheet.Properties.SheetId
+	if _, err := e.updateSpreadsheet("hide user ID column", hideIDColumn(newSheetID)); err != nil {
+		return "", fmt.Errorf("failed to hide the user ID column: %v", err)
+	}
+
+	// Header followed by one row per student
+	data := [][]interface{}{toCells(rosterHeader())}
+	for i, student := range students {
+		data = append(data, toCells(rosterRow(i+1, student)))
+	}
+	err = e.sheets.Write(e.spreadsheetID, &sheets.ValueRange{Range: sheetName + "!A1", Values: data})
+	if err != nil {
+		return "", fmt.Errorf("failed to write student data to new sheet: %v", err)
+	}
+
+	return fmt.Sprintf("https://docs.google.com/spreadsheets/d/%s/edit#gid=%d", e.spreadsheetID, newSheetID), nil
+}
+
+func (e *SheetsExporter) SyncRoster(sheetName string, students []Student) (map[string]int, error) {
+	var resp *sheets.ValueRange
+	err := e.sheets.Do("get roster", func() (err error) {
+		resp, err = e.sheets.srv.Spreadsheets.Values.Get(e.spreadsheetID, sheetName+"!A:C").Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the roster: %v", err)
+	}
+
+	table := fromCells(resp.Values)
+	if len(table) == 0 || isLegacyRoster(table[0]) {
+		// Make room for the user ID column; Number and Username move to B and C.
+		// The header goes in with the column so a failed roster write later on
+		// does not leave a sheet that still looks legacy and gets another column.
+		id, err := e.sheetID(sheetName)
+		if err != nil {
+			return nil, err
+		}
+		idHeader := rosterIDHeader
+		_, err = e.updateSpreadsheet("add user ID column", &sheets.Request{
+			InsertDimension: &sheets.InsertDimensionRequest{
+				Range: &sheets.DimensionRange{SheetId: id, Dimension: "COLUMNS", StartIndex: 0, EndIndex: 1},
+			},
+		}, &sheets.Request{
+			UpdateCells: &sheets.UpdateCellsRequest{
+				Start: &sheets.GridCoordinate{SheetId: id, RowIndex: 0, ColumnIndex: 0},
+				Rows: []*sheets.RowData{{
+					Values: []*sheets.CellData{{UserEnteredValue: &sheets.ExtendedValue{StringValue: &idHeader}}},
+				}},
+				Fields: "userEnteredValue",
+			},
+		}, hideIDColumn(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to add the user ID column: %v", err)
+		}
+		for i, row := range table {
+			table[i] = append([]string{""}, row[:min(len(row), rosterColumns-1)]...)
+		}
+	}
+
+	var roster [][]string
+	if len(table) > 1 {
+		roster = table[1:]
+	}
+	roster, rowOf := syncSheetRows(roster, students)
+
+	values := [][]interface{}{toCells(rosterHeader())}
+	for _, row := range roster {
+		values = append(values, toCells(row))
+	}
+	e.queue(sheetName, &sheets.ValueRange{Range: sheetName + "!A1", Values: values})
+	return rowOf, nil
+}
+
+func (e *SheetsExporter) UpsertDateColumn(sheetName, title string) (int, error) {
+	var headerResp *sheets.ValueRange
+	err := e.sheets.Do("get header row", func() (err error) {
+		headerResp, err = e.sheets.srv.Spreadsheets.Values.Get(e.spreadsheetID, sheetName+"!1:1").Do()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve header row: %v", err)
+	}
+
+	var header []string
+	if table := fromCells(headerResp.Values); len(table) > 0 {
+		header = table[0]
+	}
+	for index, value := range header {
+		if value == title {
+			return index, nil
+		}
+	}
+
+	columnIndex := max(len(header), rosterColumns)
+	e.queue(sheetName, &sheets.ValueRange{
+		Range:  sheetName + fmt.Sprintf("!R1C%d", columnIndex+1),
+		Values: [][]interface{}{{title}},
+	})
+	return columnIndex, nil
+}
+
+func (e *SheetsExporter) WriteMarks(sheetName string, column int, marks map[int]string) error {
+	e.mu.Lock()
+	data := e.pending[sheetName]
+	delete(e.pending, sheetName)
+	e.mu.Unlock()
+
+	if len(marks) > 0 {
+		first, last := -1, -1
+		for row := range marks {
+			if first < 0 || row < first {
+				first = row
+			}
+			if row > last {
+				last = row
+			}
+		}
+		// Null cells are skipped by the API, so rows without a mark keep theirs.
+		values := make([][]interface{}, last-first+1)
+		for i := range values {
+			values[i] = []interface{}{nil}
+		}
+		for row, mark := range marks {
+			values[row-first] = []interface{}{mark}
+		}
+		data = append(data, &sheets.ValueRange{
+			Range:  fmt.Sprintf("%s!R%dC%d:R%dC%d", sheetName, first+2, column+1, last+2, column+1),
+			Values: values,
+		})
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := e.sheets.Write(e.spreadsheetID, data...); err != nil {
+		return fmt.Errorf("failed to update sheet: %v", err)
+	}
+	return nil
+}
+
+// File returns nil: the spreadsheet is already shared through its link.
+func (e *SheetsExporter) File(sheetName string) (*Attachment, error) {
+	return nil, nil
+}
+
+func toCells(row []string) []interface{} {
+	cells := make([]interface{}, len(row))
+	for i, value := range row {
+		cells[i] = value
+	}
+	return cells
+}
+
+func fromCells(values [][]interface{}) [][]string {
+	table := make([][]string, len(values))
+	for i, row := range values {
+		table[i] = make([]string, len(row))
+		for j, value := range row {
+			table[i][j] = fmt.Sprint(value)
+		}
+	}
+	return table
+}
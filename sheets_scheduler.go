@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	sheetsBatchDelay   = 2 * time.Second  // How long writes wait for others to share their request
+	sheetsMaxAttempts  = 6                // Tries per request before giving up
+	sheetsFirstBackoff = time.Second      // Wait after the first rate limited or failed try
+	sheetsMaxBackoff   = 32 * time.Second // Longest wait between tries
+)
+
+// ==================================SHEETS SCHEDULER===========================================
+// SheetsScheduler sends every Google Sheets request through one long-lived
+// client. Requests that hit the per-minute quota (429) or a server error are
+// retried with exponential backoff, and value writes made within
+// sheetsBatchDelay of each other are sent as one Values.BatchUpdate per
+// spreadsheet.
+type SheetsScheduler struct {
+	srv *sheets.Service
+
+	mu      sync.Mutex
+	pending map[string]*writeBatch // By spreadsheet ID
+}
+
+type writeBatch struct {
+	writes []*pendingWrite
+}
+
+type pendingWrite struct {
+	data []*sheets.ValueRange
+	done chan struct{}
+	err  error
+}
+
+var (
+	sheetsMu        sync.Mutex
+	sheetsScheduler *SheetsScheduler
+)
+
+// sheetsClient returns the shared scheduler, creating the Sheets client the
+// first time it is needed. A failed attempt is not cached so fixing the
+// credentials does not require a restart.
+func sheetsClient() (*SheetsScheduler, error) {
+	sheetsMu.Lock()
+	defer sheetsMu.Unlock()
+	if sheetsScheduler != nil {
+		return sheetsScheduler, nil
+	}
+	srv, err := initSheetsService()
+	if err != nil {
+		return nil, err
+	}
+	sheetsScheduler = &SheetsScheduler{srv: srv, pending: make(map[string]*writeBatch)}
+	return sheetsScheduler, nil
+}
+
+// Do runs call, retrying it while Google answers with a rate limit or server
+// error. op names the request in logs and in the returned error.
+func (s *SheetsScheduler) Do(op string, call func() error) error {
+	backoff := sheetsFirstBackoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+		if !retryableSheetsError(err) || attempt == sheetsMaxAttempts {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+		log.Printf("Sheets %s failed (attempt %d of %d), retrying in %s: %v", op, attempt, sheetsMaxAttempts, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > sheetsMaxBackoff {
+			backoff = sheetsMaxBackoff
+		}
+	}
+}
+
+// Write queues data for spreadsheetID and waits until it has been sent
+// together with any other writes queued in the meantime.
+func (s *SheetsScheduler) Write(spreadsheetID string, data ...*sheets.ValueRange) error {
+	w := &pendingWrite{data: data, done: make(chan struct{})}
+
+	s.mu.Lock()
+	batch := s.pending[spreadsheetID]
+	if batch == nil {
+		batch = &writeBatch{}
+		s.pending[spreadsheetID] = batch
+		time.AfterFunc(sheetsBatchDelay, func() { s.flush(spreadsheetID, batch) })
+	}
+	batch.writes = append(batch.writes, w)
+	s.mu.Unlock()
+
+	<-w.done
+	return w.err
+}
+
+func (s *SheetsScheduler) flush(spreadsheetID string, batch *writeBatch) {
+	s.mu.Lock()
+	if s.pending[spreadsheetID] == batch {
+		delete(s.pending, spreadsheetID)
+	}
+	s.mu.Unlock()
+
+	var data []*sheets.ValueRange
+	for _, w := range batch.writes {
+		data = append(data, w.data...)
+	}
+	err := s.batchUpdate(spreadsheetID, data)
+
+	// A bad range (say, a sheet deleted by hand) rejects the whole batch, so
+	// send each write on its own rather than failing every class with it.
+	if err != nil && len(batch.writes) > 1 && !retryableSheetsError(err) {
+		for _, w := range batch.writes {
+			w.err = s.batchUpdate(spreadsheetID, w.data)
+			close(w.done)
+		}
+		return
+	}
+	for _, w := range batch.writes {
+		w.err = err
+		close(w.done)
+	}
+}
+
+func (s *SheetsScheduler) batchUpdate(spreadsheetID string, data []*sheets.ValueRange) error {
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "USER_ENTERED", Data: data}
+	return s.Do("batch update", func() error {
+		_, err := s.srv.Spreadsheets.Values.BatchUpdate(spreadsheetID, req).Do()
+		return err
+	})
+}
+
+// retryableSheetsError reports whether err is worth trying again: quota,
+// server and network timeout errors are; bad requests and permissions are not.
+func retryableSheetsError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ===================================Failure reports===========================================
+// Sheets failures are posted once to the guild's admin channel when they
+// start and once when updates work again, instead of on every ticker tick.
+var (
+	sheetsFailuresMu sync.Mutex
+	sheetsFailures   = make(map[string]bool) // Guild IDs with a reported failure
+)
+
+// reportSheetsFailure records err for guildID and reports whether it is a new
+// failure, posting it to the admin channel if one is configured.
+func reportSheetsFailure(s *discordgo.Session, guildID string, err error) bool {
+	log.Printf("Attendance export failed for guild %s: %v", guildID, err)
+
+	sheetsFailuresMu.Lock()
+	alreadyReported := sheetsFailures[guildID]
+	sheetsFailures[guildID] = true
+	sheetsFailuresMu.Unlock()
+	if alreadyReported {
+		return false
+	}
+
+	notifyAdmins(s, guildID, fmt.Sprintf("Attendance sheet updates are failing and will keep retrying every minute: %v", err))
+	return true
+}
+
+// clearSheetsFailure marks guildID's exports as working again.
+func clearSheetsFailure(s *discordgo.Session, guildID string) {
+	sheetsFailuresMu.Lock()
+	wasFailing := sheetsFailures[guildID]
+	delete(sheetsFailures, guildID)
+	sheetsFailuresMu.Unlock()
+
+	if wasFailing {
+		notifyAdmins(s, guildID, "Attendance sheet updates are working again.")
+	}
+}
+
+func notifyAdmins(s *discordgo.Session, guildID, content string) {
+	channelID := cfg.AdminChannelFor(guildID)
+	if channelID == "" || s == nil {
+		return
+	}
+	if _, err := s.ChannelMessageSend(channelID, content); err != nil {
+		log.Printf("Failed to notify admin channel %s: %v", channelID, err)
+	}
+}
@@ -1,71 +1,122 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"io"
 	"os"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
+// Ways of signing in to Google Sheets, chosen with `sheets_auth`.
+const (
+	SheetsAuthOAuth          = "oauth"           // User token saved by the auth subcommand
+	SheetsAuthServiceAccount = "service_account" // Service account or external account key file
+	SheetsAuthDefault        = "default"         // Application Default Credentials
+)
+
+// initSheetsService builds a Sheets client with the configured credentials.
+// It never prompts: a missing OAuth token is reported as an error telling the
+// operator to run the auth subcommand.
 func initSheetsService() (*sheets.Service, error) {
-	b, err := ioutil.ReadFile("credentials.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
-	}
+	ctx := context.Background()
 
-	config, err := google.ConfigFromJSON(b, sheets.SpreadsheetsScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+	switch cfg.SheetsAuth {
+	case SheetsAuthServiceAccount:
+		b, err := os.ReadFile(cfg.SheetsCredsFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read credentials file: %v", err)
+		}
+		creds, err := google.CredentialsFromJSON(ctx, b, sheets.SpreadsheetsScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse credentials file %s: %v", cfg.SheetsCredsFile, err)
+		}
+		return newSheetsService(ctx, option.WithCredentials(creds))
+
+	case SheetsAuthDefault:
+		creds, err := google.FindDefaultCredentials(ctx, sheets.SpreadsheetsScope)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find application default credentials: %v", err)
+		}
+		return newSheetsService(ctx, option.WithCredentials(creds))
+
+	default:
+		config, err := oauthConfig()
+		if err != nil {
+			return nil, err
+		}
+		tok, err := tokenFromFile(cfg.SheetsTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("%v (run `%s auth` once to sign in)", err, os.Args[0])
+		}
+		return newSheetsService(ctx, option.WithTokenSource(config.TokenSource(ctx, tok)))
 	}
+}
 
-	client := getClient(config)
-	srv, err := sheets.New(client)
+func newSheetsService(ctx context.Context, opts ...option.ClientOption) (*sheets.Service, error) {
+	srv, err := sheets.NewService(ctx, opts...)
 	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
+		return nil, fmt.Errorf("unable to retrieve Sheets client: %v", err)
 	}
 	return srv, nil
 }
 
-// getClient retrieves an HTTP client using OAuth configurations.
-func getClient(config *oauth2.Config) *http.Client {
-	tok, err := tokenFromFile(sheetsTokenFile)
+// oauthConfig reads the OAuth client secret file.
+func oauthConfig() (*oauth2.Config, error) {
+	b, err := os.ReadFile(cfg.SheetsCredsFile)
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		if tok == nil { // Verify token was actually retrieved
-			return nil
-		}
-		saveToken(sheetsTokenFile, tok)
+		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+	config, err := google.ConfigFromJSON(b, sheets.SpreadsheetsScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
 	}
-	return config.Client(context.Background(), tok)
+	return config, nil
 }
 
-// getTokenFromWeb handles the web-based OAuth flow to retrieve a new token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+// ===================================Auth subcommand===========================================
+// runAuth runs the one-time user OAuth flow on the terminal and saves the
+// token where the bot will look for it.
+func runAuth(in io.Reader, out io.Writer) error {
+	if cfg.SheetsAuth != SheetsAuthOAuth {
+		return fmt.Errorf("sheets_auth is %q; the auth subcommand is only needed for %q", cfg.SheetsAuth, SheetsAuthOAuth)
+	}
+	config, err := oauthConfig()
+	if err != nil {
+		return err
+	}
+
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Println("Go to the following link in your browser then type the authorization code: ")
-	fmt.Println(authURL)
-
-	var authCode string
-	fmt.Print("Enter the authorization code here: ")
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Printf("Unable to read authorization code: %v", err)
-		return nil
+	fmt.Fprintln(out, "Go to the following link in your browser then type the authorization code: ")
+	fmt.Fprintln(out, authURL)
+	fmt.Fprint(out, "Enter the authorization code here: ")
+
+	authCode, err := bufio.NewReader(in).ReadString('\n')
+	authCode = strings.TrimSpace(authCode)
+	if authCode == "" {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = errors.New("no code entered")
+		}
+		return fmt.Errorf("unable to read authorization code: %v", err)
 	}
 
 	tok, err := config.Exchange(context.Background(), authCode)
 	if err != nil {
-		log.Printf("Unable to retrieve token from web: %v", err)
-		return nil
+		return fmt.Errorf("unable to retrieve token from web: %v", err)
+	}
+	if err := saveToken(cfg.SheetsTokenFile, tok); err != nil {
+		return err
 	}
-	return tok
+	fmt.Fprintf(out, "Saved credential file to: %s\n", cfg.SheetsTokenFile)
+	return nil
 }
 
 // tokenFromFile reads an OAuth token from a file.
@@ -84,15 +135,15 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 }
 
 // saveToken saves an OAuth token to a file.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
+func saveToken(path string, token *oauth2.Token) error {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return fmt.Errorf("unable to cache oauth token: %v", err)
 	}
 	defer f.Close()
 
 	if err := json.NewEncoder(f).Encode(token); err != nil {
-		log.Fatalf("Unable to encode token to file: %v", err)
+		return fmt.Errorf("unable to encode token to file: %v", err)
 	}
+	return nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ==================================SLASH COMMANDS===========================================
+// registerSlashCommands replaces the bot's global application commands with
+// the ones declared in the command registry.
+func registerSlashCommands(s *discordgo.Session) error {
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", applicationCommands())
+	if err != nil {
+		return fmt.Errorf("unable to register slash commands: %v", err)
+	}
+	return nil
+}
+
+func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type == discordgo.InteractionMessageComponent {
+		if i.GuildID != "" && strings.HasPrefix(i.MessageComponentData().CustomID, "rolepanel:") {
+			handleRolePanelComponent(s, i)
+		}
+		return
+	}
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	ctx := newInteractionContext(s, i)
+	if ctx.GuildID == "" {
+		ctx.Reply("Commands can only be used in a server.")
+		return
+	}
+
+	dispatchSlashCommand(ctx, i.ApplicationCommandData())
+}
+
+func optionMap(options []*discordgo.ApplicationCommandInteractionDataOption) SlashOptions {
+	m := make(SlashOptions, len(options))
+	for _, opt := range options {
+		m[opt.Name] = opt
+	}
+	return m
+}
+
+// String returns the string option name, or "" when it was not given.
+func (o SlashOptions) String(name string) string {
+	if opt, ok := o[name]; ok {
+		return opt.StringValue()
+	}
+	return ""
+}
+
+// Int returns the integer option name, or 0 when it was not given.
+func (o SlashOptions) Int(name string) int {
+	if opt, ok := o[name]; ok {
+		return int(opt.IntValue())
+	}
+	return 0
+}
+
+// Bool returns the boolean option name, or false when it was not given.
+func (o SlashOptions) Bool(name string) bool {
+	if opt, ok := o[name]; ok {
+		return opt.BoolValue()
+	}
+	return false
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	maxRosterFileSize  = 1 << 20 // Largest CSV accepted by `!student import`
+	maxImportProblems  = 10      // Skipped lines listed in the import summary
+	studentListPerPage = 40      // Students per embed in `!student list`
+)
+
+// rosterCSVHeader is written by `!student export` and understood by import.
+var rosterCSVHeader = []string{"Discord ID", "Username", "Real Name", "Student Number", "Section"}
+
+// ==================================STUDENT ROSTER===========================================
+// Name returns the student's real name, or the Discord username when none is set.
+func (st Student) Name() string {
+	if st.RealName != "" {
+		return st.RealName
+	}
+	return st.Username
+}
+
+// resolveMember finds a guild member from a mention, a user ID, a username
+// or a nickname.
+func resolveMember(s *discordgo.Session, guildID, ref string) (*discordgo.Member, error) {
+	ref = strings.TrimSpace(ref)
+	if id := mentionedUserID(ref); id != "" {
+		member, err := s.GuildMember(guildID, id)
+		if err != nil {
+			return nil, fmt.Errorf("user %s is not a member of this server", ref)
+		}
+		return member, nil
+	}
+
+	name := strings.TrimPrefix(ref, "@")
+	members, err := s.GuildMembersSearch(guildID, name, 10)
+	if err != nil {
+		return nil, fmt.Errorf("unable to search members: %v", err)
+	}
+	for _, member := range members {
+		if strings.EqualFold(member.User.Username, name) || strings.EqualFold(member.Nick, name) {
+			return member, nil
+		}
+	}
+	return nil, fmt.Errorf("no member named %s", ref)
+}
+
+// mentionedUserID returns the ID in "<@id>", "<@!id>" or a bare ID, or "".
+func mentionedUserID(ref string) string {
+	if strings.HasPrefix(ref, "<@") && strings.HasSuffix(ref, ">") {
+		ref = strings.TrimPrefix(strings.TrimSuffix(ref[2:], ">"), "!")
+	}
+	if isSnowflake(ref) {
+		return ref
+	}
+	return ""
+}
+
+// forEachMember calls fn for every member of guildID, fetching them a page
+// of 1000 at a time so large guilds are not cut off.
+func forEachMember(s *discordgo.Session, guildID string, fn func(*discordgo.Member) error) error {
+	after := ""
+	for {
+		members, err := s.GuildMembers(guildID, after, 1000)
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if err := fn(member); err != nil {
+				return err
+			}
+		}
+		if len(members) < 1000 {
+			return nil
+		}
+		after = members[len(members)-1].User.ID
+	}
+}
+
+// fetchAllMembers returns every member of guildID.
+func fetchAllMembers(s *discordgo.Session, guildID string) ([]*discordgo.Member, error) {
+	var all []*discordgo.Member
+	err := forEachMember(s, guildID, func(member *discordgo.Member) error {
+		all = append(all, member)
+		return nil
+	})
+	return all, err
+}
+
+func handleStudentAdd(ctx *CommandContext, ref string, details Student) {
+	member, err := resolveMember(ctx.Session, ctx.GuildID, ref)
+	if err != nil {
+		ctx.Reply(fmt.Sprintf("Could not add student: %v.", err))
+		return
+	}
+	details.UserID = member.User.ID
+	details.Username = member.User.Username
+
+	inserted, err := upsertStudent(guildDB(ctx.GuildID), ctx.GuildID, details)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save the student.")
+		return
+	}
+	if inserted {
+		ctx.Reply(fmt.Sprintf("Added %s to the students.", member.User.Username))
+	} else {
+		ctx.Reply(fmt.Sprintf("Updated student %s.", member.User.Username))
+	}
+}
+
+func handleStudentRemove(ctx *CommandContext, ref string) {
+	db := guildDB(ctx.GuildID)
+	student, err := findStudent(db, ctx.GuildID, ref)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+	if student == nil {
+		ctx.Reply(fmt.Sprintf("%s is not a student.", ref))
+		return
+	}
+	if err := deactivateStudent(db, ctx.GuildID, student.UserID); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to remove the student.")
+		return
+	}
+	ctx.Reply(fmt.Sprintf("Removed %s from the students. Their past attendance is kept; use `purge` to delete it.", student.Name()))
+}
+
+// handleStudentPurge deletes a student, removed or not, together with their
+// enrollments, so they no longer show up in past reports.
+func handleStudentPurge(ctx *CommandContext, ref string) {
+	db := guildDB(ctx.GuildID)
+	students, err := fetchStoredStudents(db, ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+	student := matchStudent(students, ref)
+	if student == nil {
+		ctx.Reply(fmt.Sprintf("%s is not a student.", ref))
+		return
+	}
+	if err := purgeStudent(db, ctx.GuildID, student.UserID); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to purge the student.")
+		return
+	}
+	ctx.Reply(fmt.Sprintf("Deleted %s and their enrollments.", student.Name()))
+}
+
+func handleStudentRename(ctx *CommandContext, ref, realName string) {
+	db := guildDB(ctx.GuildID)
+	student, err := findStudent(db, ctx.GuildID, ref)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+	if student == nil {
+		ctx.Reply(fmt.Sprintf("%s is not a student.", ref))
+		return
+	}
+	if err := renameStudent(db, ctx.GuildID, student.UserID, realName); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to rename the student.")
+		return
+	}
+	ctx.Reply(fmt.Sprintf("%s is now listed as %s.", student.Username, realName))
+}
+
+// findStudent looks ref up among the guild's students by ID or mention, or
+// else by username or real name. It returns nil when nobody matches.
+func findStudent(db *sql.DB, guildID, ref string) (*Student, error) {
+	students, err := fetchStudents(db, guildID)
+	if err != nil {
+		return nil, err
+	}
+	return matchStudent(students, ref), nil
+}
+
+// matchStudent finds ref among students the way findStudent does.
+func matchStudent(students []Student, ref string) *Student {
+	id := mentionedUserID(strings.TrimSpace(ref))
+	name := strings.TrimPrefix(strings.TrimSpace(ref), "@")
+	for i, student := range students {
+		if student.UserID == id || (id == "" && (strings.EqualFold(student.Username, name) || strings.EqualFold(student.RealName, name))) {
+			return &students[i]
+		}
+	}
+	return nil
+}
+
+func handleStudentList(ctx *CommandContext, section string) {
+	students, err := fetchStudents(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+
+	var lines []string
+	for _, student := range students {
+		if section != "" && !strings.EqualFold(student.Section, section) {
+			continue
+		}
+		line := fmt.Sprintf("%d. <@%s>", len(lines)+1, student.UserID)
+		if student.RealName != "" {
+			line += " " + student.RealName
+		}
+		var extra []string
+		if student.StudentNumber != "" {
+			extra = append(extra, "#"+student.StudentNumber)
+		}
+		if student.Section != "" {
+			extra = append(extra, "section "+student.Section)
+		}
+		if len(extra) > 0 {
+			line += " (" + strings.Join(extra, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		if section != "" {
+			ctx.Reply(fmt.Sprintf("No students in section %s.", section))
+		} else {
+			ctx.Reply("No students are registered yet.")
+		}
+		return
+	}
+
+	title := fmt.Sprintf("Students (%d)", len(lines))
+	if section != "" {
+		title = fmt.Sprintf("Students in section %s (%d)", section, len(lines))
+	}
+	for _, embed := range pagedEmbeds(title, lines, studentListPerPage) {
+		ctx.ReplyEmbed(embed)
+	}
+}
+
+func handleStudentExport(ctx *CommandContext) {
+	students, err := fetchStudents(guildDB(ctx.GuildID), ctx.GuildID)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to fetch student data.")
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(rosterCSVHeader)
+	for _, student := range students {
+		w.Write([]string{student.UserID, student.Username, student.RealName, student.StudentNumber, student.Section})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Failed to write student CSV: %v", err)
+		ctx.Reply("Failed to export the students.")
+		return
+	}
+
+	ctx.ReplyFile(fmt.Sprintf("%d students.", len(students)), &Attachment{
+		Name:        "students.csv",
+		ContentType: "text/csv",
+		Data:        buf.Bytes(),
+	})
+}
+
+// ===================================Roster import===========================================
+// rosterRecord is one line of an imported roster.
+type rosterRecord struct {
+	line    int
+	ref     string // Discord ID, mention, username or nickname
+	student Student
+}
+
+// parseRosterCSV reads a roster CSV. With a header row the columns are found
+// by name (as written by `!student export`); without one they are
+// Discord ID or username, real name, student number, section.
+func parseRosterCSV(data []byte) ([]rosterRecord, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the CSV: %v", err)
+	}
+
+	// Column positions of ID, username, real name, number and section.
+	columns := map[string]int{"id": 0, "real name": 1, "number": 2, "section": 3}
+	start := 0
+	if len(rows) > 0 {
+		header := make(map[string]int)
+		for i, cell := range rows[0] {
+			header[strings.ToLower(strings.TrimSpace(cell))] = i
+		}
+		named := map[string][]string{
+			"id":        {"discord id", "user id", "id", "discord"},
+			"username":  {"username", "user", "discord username"},
+			"real name": {"real name", "name", "full name"},
+			"number":    {"student number", "number", "student id"},
+			"section":   {"section", "group"},
+		}
+		found := make(map[string]int)
+		for key, names := range named {
+			for _, name := range names {
+				if i, ok := header[name]; ok {
+					found[key] = i
+					break
+				}
+			}
+		}
+		_, hasID := found["id"]
+		_, hasUsername := found["username"]
+		if hasID || hasUsername {
+			columns, start = found, 1
+		}
+	}
+
+	cell := func(row []string, key string) string {
+		if i, ok := columns[key]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var records []rosterRecord
+	for i, row := range rows[start:] {
+		ref := cell(row, "id")
+		if ref == "" {
+			ref = cell(row, "username")
+		}
+		if ref == "" {
+			continue
+		}
+		records = append(records, rosterRecord{
+			line: start + i + 1,
+			ref:  ref,
+			student: Student{
+				RealName:      cell(row, "real name"),
+				StudentNumber: cell(row, "number"),
+				Section:       cell(row, "section"),
+			},
+		})
+	}
+	return records, nil
+}
+
+func handleStudentImport(ctx *CommandContext) {
+	if len(ctx.Attachments) == 0 {
+		ctx.Reply("Attach a CSV file with one student per line: Discord ID or username, real name, student number, section.")
+		return
+	}
+	ctx.Defer()
+
+	data, err := downloadAttachment(ctx.Attachments[0])
+	if err != nil {
+		log.Printf("Failed to download roster: %v", err)
+		ctx.Reply(fmt.Sprintf("Failed to download the file: %v", err))
+		return
+	}
+	records, err := parseRosterCSV(data)
+	if err != nil {
+		ctx.Reply(err.Error())
+		return
+	}
+	if len(records) == 0 {
+		ctx.Reply("The file has no students in it.")
+		return
+	}
+
+	members, err := fetchAllMembers(ctx.Session, ctx.GuildID)
+	if err != nil {
+		fmt.Println("Error fetching guild members:", err)
+		ctx.Reply("Failed to fetch members.")
+		return
+	}
+	byID := make(map[string]*discordgo.Member, len(members))
+	byName := make(map[string]*discordgo.Member, len(members))
+	for _, member := range members {
+		byID[member.User.ID] = member
+		byName[strings.ToLower(member.User.Username)] = member
+	}
+	for _, member := range members {
+		// Usernames win over nicknames when both match.
+		if nick := strings.ToLower(member.Nick); nick != "" && byName[nick] == nil {
+			byName[nick] = member
+		}
+	}
+
+	var students []Student
+	var problems []string
+	for _, record := range records {
+		member := byID[mentionedUserID(record.ref)]
+		if member == nil {
+			member = byName[strings.ToLower(strings.TrimPrefix(record.ref, "@"))]
+		}
+		if member == nil {
+			problems = append(problems, fmt.Sprintf("line %d: %s is not a member of this server", record.line, record.ref))
+			continue
+		}
+		student := record.student
+		student.UserID = member.User.ID
+		student.Username = member.User.Username
+		students = append(students, student)
+	}
+
+	added, updated, err := importStudents(guildDB(ctx.GuildID), ctx.GuildID, students)
+	if err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save the students.")
+		return
+	}
+
+	summary := fmt.Sprintf("Imported %d students: %d added, %d updated.", added+updated, added, updated)
+	if len(problems) > 0 {
+		summary += fmt.Sprintf("\nSkipped %d lines:", len(problems))
+		for i, problem := range problems {
+			if i == maxImportProblems {
+				summary += fmt.Sprintf("\n- and %d more", len(problems)-maxImportProblems)
+				break
+			}
+			summary += "\n- " + problem
+		}
+	}
+	ctx.Reply(summary)
+}
+
+func downloadAttachment(attachment *discordgo.MessageAttachment) ([]byte, error) {
+	if attachment.Size > maxRosterFileSize {
+		return nil, fmt.Errorf("%s is larger than %d KB", attachment.Filename, maxRosterFileSize/1024)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(attachment.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxRosterFileSize))
+}
+
+// ===================================Student database===========================================
+// upsertStudent saves student as active, keeping stored details that student
+// leaves empty. It reports whether the student is new or was inactive.
+func upsertStudent(db *sql.DB, guildID string, student Student) (bool, error) {
+	var exists int
+	err := db.QueryRow("SELECT COUNT(*) FROM students WHERE guild_id = ? AND user_id = ? AND active = 1", guildID, student.UserID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking student: %v", err)
+	}
+	if err := saveStudent(db, guildID, student); err != nil {
+		return false, err
+	}
+	if err := startManualEnrollment(db, guildID, student.UserID); err != nil {
+		return false, err
+	}
+	return exists == 0, nil
+}
+
+func saveStudent(db dbExecutor, guildID string, student Student) error {
+	_, err := db.Exec(`
+		INSERT INTO students (guild_id, user_id, username, real_name, student_number, section)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(guild_id, user_id) DO UPDATE SET
+			username = excluded.username,
+			real_name = CASE WHEN excluded.real_name != '' THEN excluded.real_name ELSE real_name END,
+			student_number = CASE WHEN excluded.student_number != '' THEN excluded.student_number ELSE student_number END,
+			section = CASE WHEN excluded.section != '' THEN excluded.section ELSE section END,
+			active = 1
+	`, guildID, student.UserID, student.Username, student.RealName, student.StudentNumber, student.Section)
+	if err != nil {
+		return fmt.Errorf("error saving student: %v", err)
+	}
+	return nil
+}
+
+// importStudents saves students in one transaction and counts the new and
+// updated ones.
+func importStudents(db *sql.DB, guildID string, students []Student) (added, updated int, err error) {
+	existing, err := fetchStudents(db, guildID)
+	if err != nil {
+		return 0, 0, err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, student := range existing {
+		known[student.UserID] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, student := range students {
+		if err := saveStudent(tx, guildID, student); err != nil {
+			return 0, 0, err
+		}
+		if err := startManualEnrollment(tx, guildID, student.UserID); err != nil {
+			return 0, 0, err
+		}
+		if known[student.UserID] {
+			updated++
+		} else {
+			known[student.UserID] = true
+			added++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("error saving students: %v", err)
+	}
+	return added, updated, nil
+}
+
+// fetchActiveStudentIDs returns the IDs of the active students of guildID.
+func fetchActiveStudentIDs(db *sql.DB, guildID string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT user_id FROM students WHERE guild_id = ? AND active = 1", guildID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching students: %v", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error reading student: %v", err)
+		}
+		ids[userID] = true
+	}
+	return ids, rows.Err()
+}
+
+// deactivateStudent removes a student from the roster the way losing their
+// roster roles does: the student is kept as inactive and their open
+// enrollments end now, so past reports still include them.
+func deactivateStudent(db *sql.DB, guildID, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE students SET active = 0 WHERE guild_id = ? AND user_id = ?", guildID, userID); err != nil {
+		return fmt.Errorf("error deactivating student: %v", err)
+	}
+	_, err = tx.Exec("UPDATE enrollments SET ended_at = ? WHERE guild_id = ? AND user_id = ? AND ended_at IS NULL",
+		time.Now().UTC(), guildID, userID)
+	if err != nil {
+		return fmt.Errorf("error ending enrollments: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error removing student: %v", err)
+	}
+	return nil
+}
+
+// purgeStudent deletes a student and their enrollments for good.
+func purgeStudent(db *sql.DB, guildID, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM enrollments WHERE guild_id = ? AND user_id = ?", guildID, userID); err != nil {
+		return fmt.Errorf("error deleting enrollments: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM students WHERE guild_id = ? AND user_id = ?", guildID, userID); err != nil {
+		return fmt.Errorf("error deleting student: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error purging student: %v", err)
+	}
+	return nil
+}
+
+func renameStudent(db *sql.DB, guildID, userID, realName string) error {
+	_, err := db.Exec("UPDATE students SET real_name = ? WHERE guild_id = ? AND user_id = ?", realName, guildID, userID)
+	if err != nil {
+		return fmt.Errorf("error renaming student: %v", err)
+	}
+	return nil
+}
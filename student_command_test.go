@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// countRows runs a COUNT(*) query.
+func countRows(t *testing.T, conn *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeactivateAndPurgeStudent(t *testing.T) {
+	conn := useTestDatabase(t)
+	if err := saveStudent(conn, "g1", Student{UserID: "u1", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := conn.Exec(`INSERT INTO enrollments (guild_id, user_id, role_id, started_at) VALUES (?, ?, ?, ?)`,
+		"g1", "u1", "r1", time.Now().UTC().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deactivateStudent(conn, "g1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM students WHERE guild_id = 'g1' AND user_id = 'u1' AND active = 0"); n != 1 {
+		t.Errorf("%d inactive rows for the removed student, want 1", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM enrollments WHERE user_id = 'u1' AND ended_at IS NULL"); n != 0 {
+		t.Errorf("%d enrollments still open after remove", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM enrollments WHERE user_id = 'u1'"); n != 1 {
+		t.Errorf("%d enrollments kept after remove, want 1", n)
+	}
+	students, err := fetchStoredStudents(conn, "g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if student := matchStudent(students, "alice"); student == nil {
+		t.Error("removed student cannot be found for a purge")
+	}
+
+	if err := purgeStudent(conn, "g1", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM students WHERE user_id = 'u1'"); n != 0 {
+		t.Errorf("%d student rows left after purge", n)
+	}
+	if n := countRows(t, conn, "SELECT COUNT(*) FROM enrollments WHERE user_id = 'u1'"); n != 0 {
+		t.Errorf("%d enrollments left after purge", n)
+	}
+}
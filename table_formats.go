@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ==================================CSV===========================================
+var csvFormat = tableFormat{
+	ext:         ".csv",
+	contentType: "text/csv",
+	read:        readCSVTable,
+	write:       writeCSVTable,
+}
+
+func readCSVTable(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // Marks are only written for the students present
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	return rows, nil
+}
+
+func writeCSVTable(path, sheetName string, rows [][]string) error {
+	data, err := encodeCSV(rows)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", path, err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+func encodeCSV(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to replace %s: %v", path, err)
+	}
+	return nil
+}
+
+// ==================================XLSX===========================================
+// The XLSX format writes a minimal single-sheet workbook with inline strings.
+// Reading also understands shared strings, so files saved again by Excel or
+// LibreOffice can still be updated.
+var xlsxFormat = tableFormat{
+	ext:         ".xlsx",
+	contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	read:        readXLSXTable,
+	write:       writeXLSXTable,
+}
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+func writeXLSXTable(path, sheetName string, rows [][]string) error {
+	data, err := encodeXLSX(sheetName, rows)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", path, err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// encodeXLSX builds a workbook with one sheet holding rows.
+func encodeXLSX(sheetName string, rows [][]string) ([]byte, error) {
+	var sheet strings.Builder
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(rows) > 0 && !isLegacyRoster(rows[0]) {
+		sheet.WriteString(`<cols><col min="1" max="1" width="0" hidden="1"/></cols>`)
+	}
+	sheet.WriteString(`<sheetData>`)
+	for r, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, r+1)
+		for c, value := range row {
+			if value == "" {
+				continue
+			}
+			fmt.Fprintf(&sheet, `<c r="%s%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, xlsxColumnName(c), r+1, xmlEscape(value))
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xmlEscape(xlsxSheetTitle(sheetName)))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	}
+	for _, part := range parts {
+		w, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type xlsxSheetXML struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:"t"`
+				Runs []struct {
+					Text string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+type xlsxSharedStringsXML struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+func readXLSXTable(path string) ([][]string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", path, err)
+	}
+	defer zr.Close()
+
+	var sheet xlsxSheetXML
+	var shared xlsxSharedStringsXML
+	foundSheet := false
+	for _, f := range zr.File {
+		switch f.Name {
+		case "xl/worksheets/sheet1.xml":
+			foundSheet = true
+			err = decodeZipXML(f, &sheet)
+		case "xl/sharedStrings.xml":
+			err = decodeZipXML(f, &shared)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", path, err)
+		}
+	}
+	if !foundSheet {
+		return nil, fmt.Errorf("unable to read %s: the workbook has no xl/worksheets/sheet1.xml", path)
+	}
+
+	sharedText := make([]string, len(shared.Items))
+	for i, item := range shared.Items {
+		sharedText[i] = item.Text
+		for _, run := range item.Runs {
+			sharedText[i] += run.Text
+		}
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		// Spreadsheet apps leave out empty rows, so place rows by number.
+		for row.Number > len(rows)+1 {
+			rows = append(rows, nil)
+		}
+		var cells []string
+		for c, cell := range row.Cells {
+			col := c
+			if cell.Ref != "" {
+				col = xlsxColumnIndex(cell.Ref)
+			}
+			var value string
+			switch cell.Type {
+			case "inlineStr":
+				value = cell.Inline.Text
+				for _, run := range cell.Inline.Runs {
+					value += run.Text
+				}
+			case "s":
+				if i, err := strconv.Atoi(cell.Value); err == nil && i >= 0 && i < len(sharedText) {
+					value = sharedText[i]
+				}
+			default:
+				value = cell.Value
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			cells[col] = value
+		}
+		rows = append(rows, cells)
+	}
+	return rows, nil
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// xlsxColumnName turns a zero-based column index into letters: 0 is A, 26 is AA.
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// xlsxColumnIndex returns the zero-based column of a cell reference such as "AB12".
+func xlsxColumnIndex(ref string) int {
+	index := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		index = index*26 + int(r-'A'+1)
+	}
+	return index - 1
+}
+
+// xlsxSheetTitle shortens name to a valid worksheet title.
+func xlsxSheetTitle(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		name = "Attendance"
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
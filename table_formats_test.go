@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeZip writes a zip file holding parts, keyed by name.
+func writeZip(t *testing.T, path string, parts map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, body := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXLSXRoundTrip(t *testing.T) {
+	wide := make([]string, 30) // Runs past column Z into AD
+	for i := range wide {
+		wide[i] = xlsxColumnName(i)
+	}
+	rows := [][]string{
+		{"User ID", "Name", "2024-03-04"},
+		{"1", "", "P"}, // Empty cell in the middle
+		nil,            // Empty row
+		{"2", `Tom & "Jerry" <cats>`, "L 5'"},
+		wide,
+	}
+
+	path := filepath.Join(t.TempDir(), "Math.xlsx")
+	if err := writeXLSXTable(path, "Math", rows); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readXLSXTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("read back %q, want %q", got, rows)
+	}
+}
+
+func TestReadXLSXSharedStrings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.xlsx")
+	writeZip(t, path, map[string]string{
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>Name</t></si>
+			<si><r><t>Rich </t></r><r><t>text</t></r></si>
+			<si><t>A &amp; B</t></si>
+		</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+			<row r="1"><c r="A1" t="s"><v>0</v></c><c r="AA1" t="s"><v>1</v></c></row>
+			<row r="3"><c r="B3" t="s"><v>2</v></c><c r="C3"><v>42</v></c></row>
+		</sheetData></worksheet>`,
+	})
+
+	got, err := readXLSXTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]string, 27)
+	first[0], first[26] = "Name", "Rich text"
+	want := [][]string{first, nil, {"", "A & B", "42"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestReadXLSXMissingSheet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.xlsx")
+	writeZip(t, path, map[string]string{"xl/workbook.xml": xlsxWorkbook})
+
+	rows, err := readXLSXTable(path)
+	if err == nil || !strings.Contains(err.Error(), "sheet1.xml") {
+		t.Errorf("readXLSXTable = %q, %v; want an error about the missing sheet", rows, err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ==================================GUILD TIMEZONE===========================================
+// guildTimezone returns the IANA timezone a guild reads and shows times in:
+// the one set with `!timezone`, otherwise the configured one.
+func guildTimezone(guildID string) string {
+	var name string
+	err := guildDB(guildID).QueryRow("SELECT timezone FROM guild_settings WHERE guild_id = ?", guildID).Scan(&name)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error fetching timezone for guild %s: %v", guildID, err)
+	}
+	if name == "" {
+		return cfg.TimezoneFor(guildID)
+	}
+	return name
+}
+
+// guildLocation is guildTimezone loaded as a *time.Location.
+func guildLocation(guildID string) *time.Location {
+	name := guildTimezone(guildID)
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Invalid timezone %q for guild %s: %v", name, guildID, err)
+		return time.UTC
+	}
+	return loc
+}
+
+func setGuildTimezone(db *sql.DB, guildID, name string) error {
+	_, err := db.Exec(`
+		INSERT INTO guild_settings (guild_id, timezone) VALUES (?, ?)
+		ON CONFLICT(guild_id) DO UPDATE SET timezone = excluded.timezone
+	`, guildID, name)
+	if err != nil {
+		return fmt.Errorf("error saving timezone: %v", err)
+	}
+	return nil
+}
+
+// parseClockInLocation reads an HH:MM time on the current day in loc and
+// returns it in UTC.
+func parseClockInLocation(clock string, loc *time.Location) (time.Time, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), parsed.Hour(), parsed.Minute(), 0, 0, loc).UTC(), nil
+}
+
+func handleTimezone(ctx *CommandContext, name string) {
+	if name == "" {
+		ctx.Reply(fmt.Sprintf("This server uses the %s timezone.", guildTimezone(ctx.GuildID)))
+		return
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		ctx.Reply(fmt.Sprintf("Unknown timezone '%s'. Use an IANA name such as `Asia/Bangkok` or `Europe/Berlin`.", name))
+		return
+	}
+	if err := setGuildTimezone(guildDB(ctx.GuildID), ctx.GuildID, loc.String()); err != nil {
+		log.Println(err)
+		ctx.Reply("Failed to save timezone.")
+		return
+	}
+	ctx.Reply(fmt.Sprintf("Timezone set to %s. Current time there is %s.", loc, time.Now().In(loc).Format("15:04")))
+}
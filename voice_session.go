@@ -0,0 +1,356 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// VoiceSession is a member's stay in one voice channel, backed by an
+// attendance row that is closed when they leave or move.
+type VoiceSession struct {
+	AttendanceID int64
+	ChannelID    string
+	JoinTime     time.Time
+	Flags        voiceFlags
+}
+
+// voiceFlags are the parts of a voice state that change without ending a
+// session.
+type voiceFlags struct {
+	Mute       bool
+	Deaf       bool
+	SelfMute   bool
+	SelfDeaf   bool
+	SelfStream bool
+	SelfVideo  bool
+}
+
+func flagsOf(v *discordgo.VoiceState) voiceFlags {
+	return voiceFlags{
+		Mute:       v.Mute,
+		Deaf:       v.Deaf,
+		SelfMute:   v.SelfMute,
+		SelfDeaf:   v.SelfDeaf,
+		SelfStream: v.SelfStream,
+		SelfVideo:  v.SelfVideo,
+	}
+}
+
+// changes names every flag that differs between f and to, e.g. "self_mute_on".
+func (f voiceFlags) changes(to voiceFlags) []string {
+	var events []string
+	add := func(name string, before, after bool) {
+		if before == after {
+			return
+		}
+		if after {
+			events = append(events, name+"_on")
+		} else {
+			events = append(events, name+"_off")
+		}
+	}
+	add("server_mute", f.Mute, to.Mute)
+	add("server_deaf", f.Deaf, to.Deaf)
+	add("self_mute", f.SelfMute, to.SelfMute)
+	add("self_deaf", f.SelfDeaf, to.SelfDeaf)
+	add("stream", f.SelfStream, to.SelfStream)
+	add("video", f.SelfVideo, to.SelfVideo)
+	return events
+}
+
+// ====================================VOICE STATE=========================================
+// voiceStateUpdate compares a member's previous voice state with the new one:
+// a join opens a session, a leave closes it, a move closes the old session and
+// opens a new one, and mute/deafen/stream/video changes are only logged as
+// voice events.
+func voiceStateUpdate(s *discordgo.Session, vs *discordgo.VoiceStateUpdate, state *GuildState, db *sql.DB) {
+	state.WithVoiceSessions(func(sessions map[string]*VoiceSession) {
+		applyVoiceState(s, vs, sessions, db)
+	})
+}
+
+func applyVoiceState(s *discordgo.Session, vs *discordgo.VoiceStateUpdate, sessions map[string]*VoiceSession, db *sql.DB) {
+	guildID := vs.GuildID
+	userID := vs.UserID
+	now := time.Now().UTC() // Ensure times are recorded in UTC
+
+	session := sessions[userID]
+	before := vs.BeforeUpdate
+	oldChannelID := ""
+	switch {
+	case session != nil:
+		oldChannelID = session.ChannelID
+	case before != nil:
+		oldChannelID = before.ChannelID
+	}
+
+	switch {
+	case oldChannelID == vs.ChannelID && vs.ChannelID == "":
+		return
+	case oldChannelID == vs.ChannelID:
+		if session == nil {
+			// Joined before the bot was watching; start tracking now.
+			openVoiceSession(s, sessions, db, vs.VoiceState, now)
+			return
+		}
+		previous := session.Flags
+		if before != nil {
+			previous = flagsOf(before)
+		}
+		current := flagsOf(vs.VoiceState)
+		for _, event := range previous.changes(current) {
+			if err := insertVoiceEvent(db, guildID, userID, session.AttendanceID, event, now); err != nil {
+				log.Println(err)
+			}
+		}
+		session.Flags = current
+	case vs.ChannelID == "":
+		closeVoiceSession(sessions, db, guildID, userID, now)
+	case oldChannelID == "":
+		openVoiceSession(s, sessions, db, vs.VoiceState, now)
+	default:
+		log.Printf("User %s moved to another voice channel", userID)
+		closeVoiceSession(sessions, db, guildID, userID, now)
+		openVoiceSession(s, sessions, db, vs.VoiceState, now)
+	}
+}
+
+// openVoiceSession inserts an attendance row for the channel in v.
+func openVoiceSession(s *discordgo.Session, sessions map[string]*VoiceSession, db *sql.DB, v *discordgo.VoiceState, joinTime time.Time) {
+	channelName, err := voiceChannelName(s, v.ChannelID)
+	if err != nil {
+		fmt.Println("Error getting voice channel information:", err)
+		return
+	}
+
+	fmt.Println("INSERT INTO attendance: User joined")
+
+	res, err := db.Exec("INSERT INTO attendance (guild_id, user_id, join_time, voice_channel) VALUES (?, ?, ?, ?)", v.GuildID, v.UserID, joinTime, channelName)
+	if err != nil {
+		fmt.Println("Error inserting join record:", err)
+		return
+	}
+	attendanceID, err := res.LastInsertId()
+	if err != nil {
+		fmt.Println("Error reading join record ID:", err)
+		return
+	}
+
+	sessions[v.UserID] = &VoiceSession{
+		AttendanceID: attendanceID,
+		ChannelID:    v.ChannelID,
+		JoinTime:     joinTime,
+		Flags:        flagsOf(v),
+	}
+}
+
+// closeVoiceSession sets the leave time of the member's open attendance row.
+// Without a tracked session every open row of the member is closed.
+func closeVoiceSession(sessions map[string]*VoiceSession, db *sql.DB, guildID, userID string, leaveTime time.Time) {
+	session, ok := sessions[userID]
+	if !ok {
+		_, err := db.Exec("UPDATE attendance SET leave_time = ? WHERE guild_id = ? AND user_id = ? AND leave_time IS NULL", leaveTime, guildID, userID)
+		if err != nil {
+			fmt.Println("Error inserting leave record:", err)
+		}
+		return
+	}
+
+	fmt.Println("INSERT INTO attendance: User left")
+
+	duration := leaveTime.Sub(session.JoinTime).Minutes()
+	fmt.Printf("User %s spent %.2f minutes in the channel\n", userID, duration)
+
+	_, err := db.Exec("UPDATE attendance SET leave_time = ? WHERE id = ?", leaveTime, session.AttendanceID)
+	if err != nil {
+		fmt.Println("Error inserting leave record:", err)
+		return
+	}
+	delete(sessions, userID)
+}
+
+// voiceChannelName prefers the state cache over a REST lookup.
+func voiceChannelName(s *discordgo.Session, channelID string) (string, error) {
+	if channel, err := s.State.Channel(channelID); err == nil {
+		return channel.Name, nil
+	}
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return "", err
+	}
+	return channel.Name, nil
+}
+
+func insertVoiceEvent(db *sql.DB, guildID, userID string, attendanceID int64, event string, eventTime time.Time) error {
+	_, err := db.Exec(`INSERT INTO voice_events (guild_id, user_id, attendance_id, event, event_time) VALUES (?, ?, ?, ?, ?)`,
+		guildID, userID, attendanceID, event, eventTime)
+	if err != nil {
+		return fmt.Errorf("error inserting voice event: %v", err)
+	}
+	return nil
+}
+
+// ====================================RECONCILIATION=========================================
+// heartbeatInterval is how often the bot records that it is still watching a
+// guild. Sessions left open by a crash are closed at the last heartbeat.
+const heartbeatInterval = time.Minute
+
+// reconcileVoiceSessions brings a guild's attendance rows in line with the
+// members currently in voice. It runs when a guild becomes available and after
+// gateway resumes: sessions that no longer match a voice state are closed at
+// the last heartbeat, and everyone in voice without a session gets one.
+func reconcileVoiceSessions(s *discordgo.Session, guildID string, current []*discordgo.VoiceState, state *GuildState, db *sql.DB) {
+	state.WithVoiceSessions(func(sessions map[string]*VoiceSession) {
+		reconcileSessions(s, guildID, current, sessions, db)
+	})
+}
+
+func reconcileSessions(s *discordgo.Session, guildID string, current []*discordgo.VoiceState, sessions map[string]*VoiceSession, db *sql.DB) {
+	now := time.Now().UTC()
+	lastSeen, err := fetchHeartbeat(db, guildID)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	inVoice := make(map[string]*discordgo.VoiceState)
+	for _, v := range current {
+		if v.ChannelID != "" {
+			inVoice[v.UserID] = v
+		}
+	}
+
+	// Keep tracked sessions that still match the member's channel.
+	kept := make(map[int64]bool)
+	for userID, session := range sessions {
+		if v, ok := inVoice[userID]; ok && v.ChannelID == session.ChannelID {
+			kept[session.AttendanceID] = true
+			continue
+		}
+		delete(sessions, userID)
+	}
+
+	closed, err := closeDanglingSessions(db, guildID, kept, lastSeen, now)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	opened := 0
+	for userID, v := range inVoice {
+		if sessions[userID] != nil {
+			continue
+		}
+		state := *v
+		state.GuildID = guildID // Voice states in GUILD_CREATE omit the guild ID
+		openVoiceSession(s, sessions, db, &state, now)
+		opened++
+	}
+
+	if err := saveHeartbeat(db, guildID, now); err != nil {
+		fmt.Println(err)
+	}
+	log.Printf("Reconciled voice sessions for guild %s: closed %d, opened %d", guildID, closed, opened)
+}
+
+// closeDanglingSessions closes every open attendance row of the guild that is
+// not in kept. Rows are closed at lastSeen, but never before they were opened
+// or after now.
+func closeDanglingSessions(db *sql.DB, guildID string, kept map[int64]bool, lastSeen, now time.Time) (int, error) {
+	rows, err := db.Query("SELECT id, join_time FROM attendance WHERE guild_id = ? AND leave_time IS NULL", guildID)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching open attendance records: %v", err)
+	}
+	leaveTimes := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var joinTime time.Time
+		if err := rows.Scan(&id, &joinTime); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error reading open attendance record: %v", err)
+		}
+		if kept[id] {
+			continue
+		}
+		leaveTime := lastSeen
+		if leaveTime.Before(joinTime) {
+			leaveTime = joinTime
+		}
+		if leaveTime.After(now) {
+			leaveTime = now
+		}
+		leaveTimes[id] = leaveTime.UTC()
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating through open attendance records: %v", err)
+	}
+
+	for id, leaveTime := range leaveTimes {
+		if _, err := db.Exec("UPDATE attendance SET leave_time = ? WHERE id = ?", leaveTime, id); err != nil {
+			return 0, fmt.Errorf("error closing attendance record: %v", err)
+		}
+	}
+	return len(leaveTimes), nil
+}
+
+// reconcileAllGuilds reconciles every guild in the state cache, e.g. after a
+// gateway resume.
+func reconcileAllGuilds(s *discordgo.Session) {
+	s.State.RLock()
+	current := make(map[string][]*discordgo.VoiceState)
+	for _, g := range s.State.Guilds {
+		current[g.ID] = append([]*discordgo.VoiceState(nil), g.VoiceStates...)
+	}
+	s.State.RUnlock()
+
+	for guildID, states := range current {
+		reconcileVoiceSessions(s, guildID, states, guildStates.Guild(guildID), guildDB(guildID))
+	}
+}
+
+// runHeartbeat records every heartbeatInterval that the bot is still watching
+// its guilds.
+func runHeartbeat(s *discordgo.Session) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.State.RLock()
+		var guildIDs []string
+		for _, g := range s.State.Guilds {
+			guildIDs = append(guildIDs, g.ID)
+		}
+		s.State.RUnlock()
+
+		for _, guildID := range guildIDs {
+			if err := saveHeartbeat(guildDB(guildID), guildID, now.UTC()); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// fetchHeartbeat returns the last time the bot watched guildID, or the zero
+// time if it never did.
+func fetchHeartbeat(db *sql.DB, guildID string) (time.Time, error) {
+	var seenAt sql.NullTime
+	err := db.QueryRow("SELECT seen_at FROM bot_heartbeats WHERE guild_id = ?", guildID).Scan(&seenAt)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("error fetching heartbeat: %v", err)
+	}
+	return seenAt.Time, nil
+}
+
+func saveHeartbeat(db *sql.DB, guildID string, seenAt time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO bot_heartbeats (guild_id, seen_at) VALUES (?, ?)
+		ON CONFLICT(guild_id) DO UPDATE SET seen_at = excluded.seen_at
+	`, guildID, seenAt)
+	if err != nil {
+		return fmt.Errorf("error saving heartbeat: %v", err)
+	}
+	return nil
+}